@@ -1,28 +1,80 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/it-projects-llc/hound/config"
 	"github.com/it-projects-llc/hound/index"
+	"github.com/it-projects-llc/hound/query"
 	"github.com/it-projects-llc/hound/searcher"
 )
 
 const (
 	defaultLinesOfContext uint = 2
 	maxLinesOfContext     uint = 20
+	defaultFacetDepth     uint = 1
+	maxFacetDepth         uint = 10
+
+	// The value of the syntax param asking for q to be parsed as a query
+	// with operators, see package query.
+	syntaxQuery = "query"
 )
 
 type Stats struct {
 	FilesOpened int
 	Duration    int
+
+	// The files opened whose contents were cached in memory, along with
+	// the fraction of the files opened that they are.
+	FilesCached  int
+	CacheHitRate float64
+
+	// The reasons the searches of any of the repos were truncated.
+	reasons map[string]bool
+}
+
+// Count the files opened by the search of a repo, along with why it was
+// truncated. Every response is added, including those that are left out of
+// the results for having no matches.
+func (s *Stats) add(res *index.SearchResponse) {
+	s.FilesOpened += res.FilesOpened
+	s.FilesCached += res.FilesCached
+
+	if res.Truncated {
+		if s.reasons == nil {
+			s.reasons = map[string]bool{}
+		}
+		s.reasons[res.Reason] = true
+	}
+}
+
+// Summarize the reasons any of the searches were truncated. The reasons are
+// sorted to give a stable response.
+func (s *Stats) reason() string {
+	reasons := make([]string, 0, len(s.reasons))
+	for r := range s.reasons {
+		reasons = append(reasons, r)
+	}
+	sort.Strings(reasons)
+	return strings.Join(reasons, " ")
+}
+
+// Complete the stats of a search started at startedAt.
+func (s *Stats) finish(startedAt time.Time) {
+	s.Duration = int(time.Now().Sub(startedAt).Seconds() * 1000)
+	if s.FilesOpened > 0 {
+		s.CacheHitRate = float64(s.FilesCached) / float64(s.FilesOpened)
+	}
 }
 
 func writeJson(w http.ResponseWriter, data interface{}, status int) {
@@ -38,62 +90,177 @@ func writeResp(w http.ResponseWriter, data interface{}) {
 	writeJson(w, data, http.StatusOK)
 }
 
+// Write the lines of a file as plain text. The revision they were read at
+// goes in a header.
+func writeRawFile(w http.ResponseWriter, res *index.FileResponse) {
+	w.Header().Set("Content-Type", "text/plain;charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("X-Hound-Revision", res.Revision)
+	w.WriteHeader(http.StatusOK)
+	for _, line := range res.Lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
 func writeError(w http.ResponseWriter, err error, status int) {
 	writeJson(w, map[string]string{
 		"Error": err.Error(),
 	}, status)
 }
 
-type searchResponse struct {
+// Aggregate counts of the matches across all repos, see index.Facets.
+type Facets struct {
+	Repos      map[string]*index.FacetCount
+	Extensions map[string]*index.FacetCount
+	Dirs       map[string]*index.FacetCount
+}
+
+// What searching one of the repos returned.
+type repoResponse struct {
 	repo string
-	res  *index.SearchResponse
+	res  interface{}
 	err  error
 }
 
 /**
- * Searches all repos in parallel.
+ * Calls search for each of the repos in parallel. What each call returns is
+ * delivered on the returned channel as soon as it is ready.
+ */
+func eachRepo(repos []string, search func(repo string) (interface{}, error)) <-chan *repoResponse {
+	// use a buffered channel to avoid routine leaks on errs.
+	ch := make(chan *repoResponse, len(repos))
+	for _, repo := range repos {
+		go func(repo string) {
+			res, err := search(repo)
+			ch <- &repoResponse{repo, res, err}
+		}(repo)
+	}
+
+	return ch
+}
+
+/**
+ * Starts a search of all repos in parallel. Each repo's response is delivered
+ * on the returned channel as soon as it is ready.
+ */
+func searchEach(
+	ctx context.Context,
+	clauses []index.Clause,
+	opts *index.SearchOptions,
+	repos []string,
+	idx map[string]*searcher.Searcher) <-chan *repoResponse {
+
+	return eachRepo(repos, func(repo string) (interface{}, error) {
+		return idx[repo].SearchClauses(ctx, clauses, opts)
+	})
+}
+
+/**
+ * Searches all repos in parallel. Gives up as soon as ctx is done, which
+ * also stops the searches that are still running.
  */
 func searchAll(
-	query string,
+	ctx context.Context,
+	clauses []index.Clause,
 	opts *index.SearchOptions,
 	repos []string,
 	idx map[string]*searcher.Searcher,
-	filesOpened *int,
-	duration *int) (map[string]*index.SearchResponse, error) {
+	stats *Stats) (map[string]*index.SearchResponse, error) {
 
 	startedAt := time.Now()
 
 	n := len(repos)
 
-	// use a buffered channel to avoid routine leaks on errs.
-	ch := make(chan *searchResponse, n)
-	for _, repo := range repos {
-		go func(repo string) {
-			fms, err := idx[repo].Search(query, opts)
-			ch <- &searchResponse{repo, fms, err}
-		}(repo)
-	}
+	ch := searchEach(ctx, clauses, opts, repos, idx)
 
 	res := map[string]*index.SearchResponse{}
 	for i := 0; i < n; i++ {
-		r := <-ch
+		var r *repoResponse
+		select {
+		case r = <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
 		if r.err != nil {
 			return nil, r.err
 		}
+		sr := r.res.(*index.SearchResponse)
+
+		stats.add(sr)
 
-		if r.res.Matches == nil {
+		// count only searches have matches but return none of them.
+		if sr.Matches == nil && sr.LinesWithMatch == 0 {
 			continue
 		}
 
-		res[r.repo] = r.res
-		*filesOpened += r.res.FilesOpened
+		res[r.repo] = sr
 	}
 
-	*duration = int(time.Now().Sub(startedAt).Seconds() * 1000)
+	stats.finish(startedAt)
 
 	return res, nil
 }
 
+/**
+ * Searches all repos in parallel and writes each repo's response to the
+ * stream as soon as it is ready. The stream always ends with a frame
+ * holding the Stats, unless a repo fails in which case it ends with an
+ * error frame. Searches for facets get a frame holding the facets of all
+ * repos just before the Stats.
+ */
+func searchAllToStream(
+	ctx context.Context,
+	sw *streamWriter,
+	clauses []index.Clause,
+	opts *index.SearchOptions,
+	repos []string,
+	idx map[string]*searcher.Searcher) error {
+
+	startedAt := time.Now()
+
+	n := len(repos)
+
+	ch := searchEach(ctx, clauses, opts, repos, idx)
+
+	var stats Stats
+	results := map[string]*index.SearchResponse{}
+	for i := 0; i < n; i++ {
+		var r *repoResponse
+		select {
+		case r = <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if r.err != nil {
+			return sw.WriteError(r.err)
+		}
+		sr := r.res.(*index.SearchResponse)
+
+		stats.add(sr)
+
+		// count only searches have matches but return none of them.
+		if sr.Matches == nil && sr.LinesWithMatch == 0 {
+			continue
+		}
+
+		if err := sw.WriteResult(r.repo, sr); err != nil {
+			return err
+		}
+		results[r.repo] = sr
+	}
+
+	if opts.Facets {
+		if err := sw.WriteFacets(mergeFacets(results)); err != nil {
+			return err
+		}
+	}
+
+	stats.finish(startedAt)
+	return sw.WriteStats(&stats)
+}
+
 // Used for parsing flags from form values.
 func parseAsBool(v string) bool {
 	v = strings.ToLower(v)
@@ -159,7 +326,118 @@ func parseRangeValue(rv string) (int, int) {
 	return b, e
 }
 
-func Setup(m *http.ServeMux, idx map[string]*searcher.Searcher) {
+// Parse the budget requested by the client, never allowing it to exceed the
+// budget of the server. A zero server budget means the server has no limit.
+func parseBudget(v string, server int) int {
+	iv, err := strconv.ParseUint(v, 10, 32)
+	if err != nil || iv == 0 {
+		return server
+	}
+
+	if server > 0 && int(iv) > server {
+		return server
+	}
+
+	return int(iv)
+}
+
+// Populate the search options from the form values of the request.
+func parseSearchOptions(r *http.Request, cfg *config.Config, opt *index.SearchOptions) {
+	opt.Offset, opt.Limit = parseRangeValue(r.FormValue("rng"))
+	opt.FileRegexp = r.FormValue("files")
+	opt.ExcludeFileRegexp = r.FormValue("excludeFiles")
+	if langs := r.FormValue("langs"); langs != "" {
+		opt.Languages = strings.Split(strings.ToLower(langs), ",")
+	}
+	opt.IgnoreCase = parseAsBool(r.FormValue("i"))
+	opt.Literal = parseAsBool(r.FormValue("literal"))
+	opt.WholeWord = parseAsBool(r.FormValue("word"))
+	opt.Multiline = parseAsBool(r.FormValue("multiline"))
+	opt.LinesOfContext = parseAsUintValue(
+		r.FormValue("ctx"),
+		0,
+		maxLinesOfContext,
+		defaultLinesOfContext)
+	opt.Facets = parseAsBool(r.FormValue("facets"))
+	opt.FacetDepth = int(parseAsUintValue(
+		r.FormValue("facetdepth"),
+		0,
+		maxFacetDepth,
+		defaultFacetDepth))
+	opt.CountOnly = parseAsBool(r.FormValue("countonly"))
+	opt.Rank = parseAsBool(r.FormValue("rank"))
+	opt.IdentStyle = parseAsBool(r.FormValue("ident"))
+	opt.DefinitionsOnly = parseAsBool(r.FormValue("defs"))
+	opt.MaxMatches = parseBudget(r.FormValue("maxmatches"), cfg.SearchMaxMatches)
+	opt.Workers = cfg.SearchWorkers
+	opt.Timeout = time.Duration(
+		parseBudget(r.FormValue("timeout"), cfg.MsSearchTimeout)) * time.Millisecond
+}
+
+// Parse the query and the search options of the request. The query is a
+// plain pattern unless the request asks for the query syntax, in which case
+// operators in the query take precedence over the corresponding form
+// values. Returns the clauses to search for and the repos to search.
+func parseSearchRequest(
+	r *http.Request,
+	cfg *config.Config,
+	idx map[string]*searcher.Searcher,
+	opt *index.SearchOptions) ([]index.Clause, []string, error) {
+
+	parseSearchOptions(r, cfg, opt)
+
+	q := query.Raw(r.FormValue("q"))
+	if r.FormValue("syntax") == syntaxQuery {
+		var err error
+		q, err = query.Parse(r.FormValue("q"))
+		if err != nil {
+			return nil, nil, err
+		}
+		q.Apply(opt)
+	}
+
+	repos := q.FilterRepos(parseAsRepoList(r.FormValue("repos"), idx))
+	return q.Clauses, repos, nil
+}
+
+// The spellings each pattern was expanded into by an identifier search.
+func expansionsFor(clauses []index.Clause) map[string][]string {
+	res := map[string][]string{}
+	for _, clause := range clauses {
+		for _, t := range clause {
+			res[t.Pattern] = index.ExpandIdentifier(t.Pattern)
+		}
+	}
+	return res
+}
+
+// Add up the facets of every repo.
+func mergeFacets(results map[string]*index.SearchResponse) *Facets {
+	f := &Facets{
+		Repos: map[string]*index.FacetCount{},
+	}
+	all := &index.Facets{
+		Extensions: map[string]*index.FacetCount{},
+		Dirs:       map[string]*index.FacetCount{},
+	}
+
+	for repo, res := range results {
+		if res.Facets == nil {
+			continue
+		}
+		f.Repos[repo] = &index.FacetCount{
+			Files: res.FilesWithMatch,
+			Lines: res.LinesWithMatch,
+		}
+		all.Merge(res.Facets)
+	}
+
+	f.Extensions = all.Extensions
+	f.Dirs = all.Dirs
+	return f
+}
+
+func Setup(m *http.ServeMux, cfg *config.Config, idx map[string]*searcher.Searcher) {
 
 	m.HandleFunc("/api/v1/repos", func(w http.ResponseWriter, r *http.Request) {
 		res := map[string]*config.Repo{}
@@ -174,21 +452,29 @@ func Setup(m *http.ServeMux, idx map[string]*searcher.Searcher) {
 		var opt index.SearchOptions
 
 		stats := parseAsBool(r.FormValue("stats"))
-		repos := parseAsRepoList(r.FormValue("repos"), idx)
-		query := r.FormValue("q")
-		opt.Offset, opt.Limit = parseRangeValue(r.FormValue("rng"))
-		opt.FileRegexp = r.FormValue("files")
-		opt.IgnoreCase = parseAsBool(r.FormValue("i"))
-		opt.LinesOfContext = parseAsUintValue(
-			r.FormValue("ctx"),
-			0,
-			maxLinesOfContext,
-			defaultLinesOfContext)
-
-		var filesOpened int
-		var durationMs int
-
-		results, err := searchAll(query, &opt, repos, idx, &filesOpened, &durationMs)
+		clauses, repos, err := parseSearchRequest(r, cfg, idx, &opt)
+		if err != nil {
+			writeError(w, err, http.StatusOK)
+			return
+		}
+
+		var st Stats
+
+		var results map[string]*index.SearchResponse
+		var next *cursor
+		if wantsPage(r) {
+			var cur *cursor
+			cur, err = parseCursor(r.FormValue("cursor"))
+			if err != nil {
+				writeError(w, err, http.StatusBadRequest)
+				return
+			}
+
+			limit := int(parseAsUintValue(r.FormValue("limit"), 1, maxPageLimit, defaultPageLimit))
+			results, next, err = searchPage(r.Context(), clauses, &opt, repos, idx, cur, limit, &st)
+		} else {
+			results, err = searchAll(r.Context(), clauses, &opt, repos, idx, &st)
+		}
 		if err != nil {
 			// TODO(knorton): Return ok status because the UI expects it for now.
 			writeError(w, err, http.StatusOK)
@@ -196,21 +482,130 @@ func Setup(m *http.ServeMux, idx map[string]*searcher.Searcher) {
 		}
 
 		var res struct {
-			Results map[string]*index.SearchResponse
-			Stats   *Stats `json:",omitempty"`
+			Results   map[string]*index.SearchResponse
+			Stats     *Stats              `json:",omitempty"`
+			Facets    *Facets             `json:",omitempty"`
+			Truncated bool                `json:",omitempty"`
+			Reason    string              `json:",omitempty"`
+			Cursor    string              `json:",omitempty"`
+			Expansion map[string][]string `json:",omitempty"`
 		}
 
 		res.Results = results
+		if opt.IdentStyle {
+			res.Expansion = expansionsFor(clauses)
+		}
+		res.Cursor = next.String()
+		if opt.Facets {
+			res.Facets = mergeFacets(results)
+		}
+		res.Reason = st.reason()
+		res.Truncated = res.Reason != ""
 		if stats {
-			res.Stats = &Stats{
-				FilesOpened: filesOpened,
-				Duration:    durationMs,
-			}
+			res.Stats = &st
+		}
+
+		writeResp(w, &res)
+	})
+
+	m.HandleFunc("/api/v1/search/stream", func(w http.ResponseWriter, r *http.Request) {
+		var opt index.SearchOptions
+
+		sw := newStreamWriter(w, wantsEventStream(r))
+
+		clauses, repos, err := parseSearchRequest(r, cfg, idx, &opt)
+		if err != nil {
+			err = sw.WriteError(err)
+		} else {
+			err = searchAllToStream(r.Context(), sw, clauses, &opt, repos, idx)
+		}
+		if err != nil {
+			log.Printf("Failed to write search stream: %v\n", err)
+		}
+	})
+
+	m.HandleFunc("/api/v1/explain", func(w http.ResponseWriter, r *http.Request) {
+		var opt index.SearchOptions
+
+		clauses, repos, err := parseSearchRequest(r, cfg, idx, &opt)
+		if err != nil {
+			writeError(w, err, http.StatusOK)
+			return
+		}
+
+		results, err := explainAll(r.Context(), clauses, &opt, repos, idx)
+		if err != nil {
+			writeError(w, err, http.StatusOK)
+			return
 		}
 
+		var res struct {
+			Results map[string]*index.Explanation
+		}
+
+		res.Results = results
 		writeResp(w, &res)
 	})
 
+	m.HandleFunc("/api/v1/files", func(w http.ResponseWriter, r *http.Request) {
+		var opt index.PathSearchOptions
+		parsePathSearchOptions(r, &opt)
+		repos := parseAsRepoList(r.FormValue("repos"), idx)
+
+		res, err := searchPaths(r.FormValue("q"), &opt, repos, idx)
+		if err != nil {
+			writeError(w, err, http.StatusOK)
+			return
+		}
+
+		writeResp(w, res)
+	})
+
+	m.HandleFunc("/api/v1/symbols", func(w http.ResponseWriter, r *http.Request) {
+		var opt index.SymbolSearchOptions
+		parseSymbolSearchOptions(r, &opt)
+		repos := parseAsRepoList(r.FormValue("repos"), idx)
+
+		res, err := searchSymbols(r.FormValue("q"), &opt, repos, idx)
+		if err != nil {
+			writeError(w, err, http.StatusOK)
+			return
+		}
+
+		writeResp(w, res)
+	})
+
+	m.HandleFunc("/api/v1/file", func(w http.ResponseWriter, r *http.Request) {
+		repo := r.FormValue("repo")
+		searcher := idx[repo]
+		if searcher == nil {
+			writeError(w,
+				fmt.Errorf("No such repository: %s", repo),
+				http.StatusNotFound)
+			return
+		}
+
+		var from, to int
+		parseRangeInt(r.FormValue("from"), &from)
+		parseRangeInt(r.FormValue("to"), &to)
+
+		res, err := searcher.ReadFile(r.FormValue("path"), from, to)
+		if err == index.ErrFileNotFound || os.IsNotExist(err) {
+			writeError(w, err, http.StatusNotFound)
+			return
+		} else if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if strings.ToLower(r.FormValue("format")) == "raw" {
+			writeRawFile(w, res)
+			return
+		}
+
+		writeResp(w, res)
+	})
+
 	m.HandleFunc("/api/v1/excludes", func(w http.ResponseWriter, r *http.Request) {
 		repo := r.FormValue("repo")
 		res := idx[repo].GetExcludedFiles()
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/it-projects-llc/hound/config"
+	"github.com/it-projects-llc/hound/searcher"
+)
+
+// The contents of the files of a repo by path.
+type testRepo map[string]string
+
+// An API serving searchers of test repos, each a git repository holding
+// the files of the repo in a single commit.
+type testAPI struct {
+	mux       *http.ServeMux
+	cfg       *config.Config
+	searchers map[string]*searcher.Searcher
+	dir       string
+}
+
+func git(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %s: %s", args, err, out)
+	}
+}
+
+func setupAPI(t *testing.T, repos map[string]testRepo) *testAPI {
+	dir, err := ioutil.TempDir(os.TempDir(), "hound-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &testAPI{
+		mux: http.NewServeMux(),
+		cfg: &config.Config{
+			DbPath:           filepath.Join(dir, "db"),
+			SearchMaxMatches: 5000,
+			SearchWorkers:    2,
+		},
+		searchers: map[string]*searcher.Searcher{},
+		dir:       dir,
+	}
+
+	if err := os.MkdirAll(a.cfg.DbPath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	off := false
+	for name, files := range repos {
+		src := filepath.Join(dir, "src", name)
+		if err := os.MkdirAll(src, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		git(t, src, "init", "-q")
+		git(t, src, "symbolic-ref", "HEAD", "refs/heads/master")
+		for path, content := range files {
+			filename := filepath.Join(src, path)
+			if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		git(t, src, "add", "-A")
+		git(t, src, "-c", "user.name=hound", "-c", "user.email=hound@example.com", "commit", "-q", "-m", "test")
+
+		s, err := searcher.New(a.cfg.DbPath, name, &config.Repo{
+			Url:               "file://" + src,
+			Vcs:               "git",
+			EnablePollUpdates: &off,
+			EnablePushUpdates: &off,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.searchers[name] = s
+	}
+
+	Setup(a.mux, a.cfg, a.searchers)
+	return a
+}
+
+func (a *testAPI) Close() {
+	for _, s := range a.searchers {
+		s.Stop()
+		s.Wait()
+	}
+	os.RemoveAll(a.dir)
+}
+
+// Serve a request for the path with the given form values and headers.
+func (a *testAPI) get(path string, values url.Values, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path+"?"+values.Encode(), nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	rec := httptest.NewRecorder()
+	a.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestReadFileNotFound(t *testing.T) {
+	a := setupAPI(t, map[string]testRepo{
+		"a": {"docs/a.md": "# a\n"},
+	})
+	defer a.Close()
+
+	if rec := a.get("/api/v1/file", url.Values{"repo": {"a"}, "path": {"docs/a.md"}}, nil); rec.Code != http.StatusOK {
+		t.Fatalf("reading a file: status %d", rec.Code)
+	}
+
+	for _, path := range []string{"docs", ".", "nope.md", "../a/docs/a.md"} {
+		rec := a.get("/api/v1/file", url.Values{"repo": {"a"}, "path": {path}}, nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%q: status %d, want %d", path, rec.Code, http.StatusNotFound)
+		}
+	}
+}
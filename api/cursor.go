@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/it-projects-llc/hound/index"
+	"github.com/it-projects-llc/hound/searcher"
+)
+
+const (
+	defaultPageLimit uint = 50
+	maxPageLimit     uint = 1000
+)
+
+var (
+	errInvalidCursor = errors.New("Invalid cursor")
+	errNoProgress    = errors.New("Search timed out before finding the rest of the page")
+)
+
+// The position of the last file on a page of results. Clients get cursors
+// as opaque tokens to hand back for the next page. A page that ended part
+// way through the matches of the file has the number of its matches that
+// were on the page or earlier ones in Skip.
+type cursor struct {
+	Repo string
+	Path string
+	Skip int `json:",omitempty"`
+}
+
+func (c *cursor) String() string {
+	if c == nil {
+		return ""
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseCursor(v string) (*cursor, error) {
+	if v == "" {
+		return &cursor{}, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, errInvalidCursor
+	}
+	return &c, nil
+}
+
+// Does the request ask for a page of results rather than all of them?
+func wantsPage(r *http.Request) bool {
+	return r.FormValue("cursor") != "" || r.FormValue("limit") != ""
+}
+
+/**
+ * Searches the repos for one page of results in a stable order: by repo
+ * name, then by path. The repos are searched in parallel like searchAll,
+ * each for a whole page, and the page is filled from them in order. The
+ * searches still running once it is full are abandoned. Returns the cursor
+ * for the next page, which is nil on the last page.
+ */
+func searchPage(
+	ctx context.Context,
+	clauses []index.Clause,
+	opts *index.SearchOptions,
+	repos []string,
+	idx map[string]*searcher.Searcher,
+	cur *cursor,
+	limit int,
+	stats *Stats) (map[string]*index.SearchResponse, *cursor, error) {
+
+	startedAt := time.Now()
+
+	sort.Strings(repos)
+
+	var todo []string
+	for _, repo := range repos {
+		if repo >= cur.Repo {
+			todo = append(todo, repo)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := eachRepo(todo, func(repo string) (interface{}, error) {
+		opt := *opts
+		opt.Paged = true
+		opt.Offset = 0
+		opt.Limit = limit
+		opt.After, opt.AfterMatches = "", 0
+		if repo == cur.Repo {
+			opt.After, opt.AfterMatches = cur.Path, cur.Skip
+		}
+		return idx[repo].SearchClauses(ctx, clauses, &opt)
+	})
+
+	// the responses arrive in any order, but fill the page in repo order.
+	arrived := map[string]*repoResponse{}
+	wait := func(repo string) (*index.SearchResponse, error) {
+		for arrived[repo] == nil {
+			select {
+			case r := <-ch:
+				arrived[r.repo] = r
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		r := arrived[repo]
+		if r.err != nil {
+			return nil, r.err
+		}
+		return r.res.(*index.SearchResponse), nil
+	}
+
+	res := map[string]*index.SearchResponse{}
+	var next *cursor
+	for i, repo := range todo {
+		r, err := wait(repo)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// the response may be shared with the result cache of the repo.
+		page := *r
+		r = &page
+
+		// the page may be full before the files of the repo run out, the
+		// last of those on the page has all of its matches then.
+		full := len(r.Matches) >= limit
+		if len(r.Matches) > limit {
+			r.Matches = r.Matches[:limit]
+			r.Truncated, r.Reason, r.HasMore = false, "", true
+		}
+		stats.add(r)
+
+		if n := len(r.Matches); n > 0 {
+			res[repo] = r
+			limit -= n
+			next = &cursor{repo, r.Matches[n-1].Filename, 0}
+		}
+
+		// a truncated repo resumes where its search stopped on the next
+		// page, which needs to be further on than this one started.
+		if r.Truncated {
+			next = &cursor{repo, r.After, r.AfterMatches}
+			if *next == *cur {
+				return nil, nil, errNoProgress
+			}
+			break
+		}
+
+		// when the page ends with the last file of a repo, the next page
+		// may turn out to be empty.
+		if full {
+			if !r.HasMore && i == len(todo)-1 {
+				next = nil
+			}
+			break
+		}
+
+		if i == len(todo)-1 {
+			next = nil
+		}
+	}
+
+	stats.finish(startedAt)
+
+	return res, next, nil
+}
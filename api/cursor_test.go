@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/it-projects-llc/hound/index"
+)
+
+var pageRepos = map[string]testRepo{
+	"a": {"x.go": "needle 1\nneedle 2\nneedle 3\nneedle 4\nneedle 5\n"},
+	"b": {"y.go": "needle 6\nneedle 7\n", "z.go": "needle 8\n"},
+	"c": {"README": "nothing\n"},
+}
+
+func TestSearchPages(t *testing.T) {
+	a := setupAPI(t, pageRepos)
+	defer a.Close()
+
+	var lines []string
+	cur := ""
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatalf("too many pages, got %v so far", lines)
+		}
+
+		rec := a.get("/api/v1/search", url.Values{
+			"q":          {"needle"},
+			"repos":      {"*"},
+			"limit":      {"2"},
+			"maxmatches": {"3"},
+			"cursor":     {cur},
+		}, nil)
+
+		var res struct {
+			Results map[string]*index.SearchResponse
+			Cursor  string
+			Error   string
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatalf("bad response %q: %s", rec.Body.String(), err)
+		}
+		if res.Error != "" {
+			t.Fatal(res.Error)
+		}
+
+		for _, repo := range []string{"a", "b", "c"} {
+			if r := res.Results[repo]; r != nil {
+				for _, fm := range r.Matches {
+					for _, m := range fm.Matches {
+						lines = append(lines, m.Line)
+					}
+				}
+			}
+		}
+
+		if res.Cursor == "" {
+			break
+		}
+		cur = res.Cursor
+	}
+
+	expected := []string{
+		"needle 1", "needle 2", "needle 3", "needle 4",
+		"needle 5", "needle 6", "needle 7", "needle 8",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("expected %v, got %v", expected, lines)
+	}
+}
+
+func TestSearchPageWithoutProgress(t *testing.T) {
+	a := setupAPI(t, pageRepos)
+	defer a.Close()
+
+	// every search runs out of time before grepping a single file.
+	opt := &index.SearchOptions{Timeout: time.Nanosecond}
+	clauses := []index.Clause{{{Pattern: "needle"}}}
+
+	res, next, err := searchPage(context.Background(), clauses, opt, []string{"a", "b"}, a.searchers, &cursor{}, 10, &Stats{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 0 || next == nil || *next != (cursor{Repo: "a"}) {
+		t.Fatalf("expected an empty page resuming at a, got %v %+v", res, next)
+	}
+
+	if _, _, err := searchPage(context.Background(), clauses, opt, []string{"a", "b"}, a.searchers, next, 10, &Stats{}); err != errNoProgress {
+		t.Fatalf("expected %v for a page without progress, got %v", errNoProgress, err)
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+
+	"github.com/it-projects-llc/hound/index"
+	"github.com/it-projects-llc/hound/searcher"
+)
+
+type explainResponse struct {
+	repo string
+	res  *index.Explanation
+	err  error
+}
+
+/**
+ * Explains the search in all repos in parallel.
+ */
+func explainAll(
+	ctx context.Context,
+	clauses []index.Clause,
+	opts *index.SearchOptions,
+	repos []string,
+	idx map[string]*searcher.Searcher) (map[string]*index.Explanation, error) {
+
+	// use a buffered channel to avoid routine leaks on errs.
+	ch := make(chan *explainResponse, len(repos))
+	for _, repo := range repos {
+		go func(repo string) {
+			res, err := idx[repo].Explain(ctx, clauses, opts)
+			ch <- &explainResponse{repo, res, err}
+		}(repo)
+	}
+
+	res := map[string]*index.Explanation{}
+	for range repos {
+		var r *explainResponse
+		select {
+		case r = <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		res[r.repo] = r.res
+	}
+
+	return res, nil
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/it-projects-llc/hound/index"
+	"github.com/it-projects-llc/hound/searcher"
+)
+
+const (
+	defaultPathLimit uint = 100
+	maxPathLimit     uint = 1000
+)
+
+// A path matched in one of the repos.
+type PathMatch struct {
+	Repo string
+	*index.PathMatch
+}
+
+type pathsResponse struct {
+	Matches   []*PathMatch
+	Truncated bool `json:",omitempty"`
+}
+
+// Populate the path search options from the form values of the request.
+func parsePathSearchOptions(r *http.Request, opt *index.PathSearchOptions) {
+	opt.IgnoreCase = parseAsBool(r.FormValue("i"))
+	opt.Literal = parseAsBool(r.FormValue("literal"))
+	opt.IncludeExcluded = parseAsBool(r.FormValue("excluded"))
+	opt.Limit = int(parseAsUintValue(
+		r.FormValue("limit"),
+		0,
+		maxPathLimit,
+		defaultPathLimit))
+}
+
+/**
+ * Searches the paths of the files in all repos in parallel and ranks the
+ * matches from all of them together. Each repo contributes at most the limit
+ * of matches, so the ones left over after ranking are enough to fill the
+ * response.
+ */
+func searchPaths(
+	pat string,
+	opt *index.PathSearchOptions,
+	repos []string,
+	idx map[string]*searcher.Searcher) (*pathsResponse, error) {
+
+	ch := eachRepo(repos, func(repo string) (interface{}, error) {
+		return idx[repo].SearchPaths(pat, opt)
+	})
+
+	res := &pathsResponse{}
+	for range repos {
+		rr := <-ch
+		if rr.err != nil {
+			return nil, rr.err
+		}
+		repo, r := rr.repo, rr.res.(*index.PathSearchResponse)
+
+		for _, m := range r.Matches {
+			res.Matches = append(res.Matches, &PathMatch{repo, m})
+		}
+		res.Truncated = res.Truncated || r.Truncated
+	}
+
+	sort.Slice(res.Matches, func(i, j int) bool {
+		a, b := res.Matches[i], res.Matches[j]
+		if index.LessPathMatch(a.PathMatch, b.PathMatch) {
+			return true
+		} else if index.LessPathMatch(b.PathMatch, a.PathMatch) {
+			return false
+		}
+		return a.Repo < b.Repo
+	})
+
+	if opt.Limit > 0 && len(res.Matches) > opt.Limit {
+		res.Matches = res.Matches[:opt.Limit]
+		res.Truncated = true
+	}
+
+	return res, nil
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestSearchPathsAcrossRepos(t *testing.T) {
+	a := setupAPI(t, map[string]testRepo{
+		"a": {"server.go": "package a\n", "docs/server.md": "docs\n"},
+		"b": {"server.go": "package b\n", "client.go": "package b\n"},
+		"c": {"README": "nothing\n"},
+	})
+	defer a.Close()
+
+	rec := a.get("/api/v1/files", url.Values{"q": {"server"}, "repos": {"*"}}, nil)
+
+	var res pathsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("bad response %q: %s", rec.Body.String(), err)
+	}
+
+	var got []string
+	for _, m := range res.Matches {
+		got = append(got, m.Repo+"/"+m.Filename)
+	}
+
+	want := []string{"a/server.go", "b/server.go", "a/docs/server.md"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	rec = a.get("/api/v1/files", url.Values{"q": {"server"}, "repos": {"*"}, "limit": {"1"}}, nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Matches) != 1 || !res.Truncated {
+		t.Fatalf("expected a single match and truncation, got %d %t", len(res.Matches), res.Truncated)
+	}
+}
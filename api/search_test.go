@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/it-projects-llc/hound/index"
+)
+
+var syntaxRepos = map[string]testRepo{
+	"a": {
+		"main.go": "package main\n\n" +
+			"var greeting = \"hello\"\n" +
+			"var open = \"unterminated\n" +
+			"// if a AND b OR NOT c\n" +
+			"// see file:12 and repo:b\n",
+		"other.go": "package main\n\nvar hello = 1\n",
+	},
+	"b": {"lib.go": "package lib\n\nvar hello = 2\n"},
+}
+
+type searchResult struct {
+	Results map[string]*index.SearchResponse
+	Error   string
+}
+
+func (a *testAPI) search(t *testing.T, values url.Values) *searchResult {
+	values.Set("repos", "*")
+	rec := a.get("/api/v1/search", values, nil)
+
+	var res searchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("bad response %q: %s", rec.Body.String(), err)
+	}
+	return &res
+}
+
+// The lines matched in each repo, by file.
+func matchedLines(res *searchResult) map[string][]string {
+	lines := map[string][]string{}
+	for repo, r := range res.Results {
+		for _, fm := range r.Matches {
+			for _, m := range fm.Matches {
+				lines[repo+"/"+fm.Filename] = append(lines[repo+"/"+fm.Filename], m.Line)
+			}
+		}
+	}
+	return lines
+}
+
+func TestSearchRawPatterns(t *testing.T) {
+	a := setupAPI(t, syntaxRepos)
+	defer a.Close()
+
+	tests := []struct {
+		q    string
+		line string
+	}{
+		{`"hello"`, `var greeting = "hello"`},
+		{`= "unterminated`, `var open = "unterminated`},
+		{`a AND b OR NOT c`, `// if a AND b OR NOT c`},
+		{`NOT c`, `// if a AND b OR NOT c`},
+		{`file:\d+`, `// see file:12 and repo:b`},
+		{`repo:b`, `// see file:12 and repo:b`},
+	}
+
+	for _, test := range tests {
+		res := a.search(t, url.Values{"q": {test.q}})
+		if res.Error != "" {
+			t.Errorf("%q: %s", test.q, res.Error)
+			continue
+		}
+
+		lines := matchedLines(res)
+		if len(lines) != 1 || len(lines["a/main.go"]) != 1 || lines["a/main.go"][0] != test.line {
+			t.Errorf("%q matched %q, want only %q", test.q, lines, test.line)
+		}
+	}
+}
+
+func TestSearchQuerySyntax(t *testing.T) {
+	a := setupAPI(t, syntaxRepos)
+	defer a.Close()
+
+	res := a.search(t, url.Values{"q": {`repo:b hello`}, "syntax": {"query"}})
+	if lines := matchedLines(res); len(lines) != 1 || len(lines["b/lib.go"]) != 1 {
+		t.Errorf("repo:b hello matched %q", lines)
+	}
+
+	res = a.search(t, url.Values{"q": {`"hello"`}, "syntax": {"query"}})
+	if lines := matchedLines(res); len(lines) != 3 {
+		t.Errorf("a quoted pattern matched %q", lines)
+	}
+
+	res = a.search(t, url.Values{"q": {`"unterminated`}, "syntax": {"query"}})
+	if res.Error == "" {
+		t.Error("an unbalanced quote was accepted by the query syntax")
+	}
+}
+
+func TestSearchTimedOutWithoutMatches(t *testing.T) {
+	a := setupAPI(t, syntaxRepos)
+	defer a.Close()
+
+	// every search runs out of time before grepping a single file.
+	opt := &index.SearchOptions{Timeout: time.Nanosecond}
+	clauses := []index.Clause{{{Pattern: "hello"}}}
+
+	var st Stats
+	res, err := searchAll(context.Background(), clauses, opt, []string{"a", "b"}, a.searchers, &st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 0 {
+		t.Fatalf("expected no results, got %v", res)
+	}
+	if r := st.reason(); r != index.ReasonTimeLimit {
+		t.Fatalf("expected the search to be truncated by the time limit, got %q", r)
+	}
+}
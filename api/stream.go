@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/it-projects-llc/hound/index"
+)
+
+const (
+	streamEventResult = "result"
+	streamEventError  = "error"
+	streamEventStats  = "stats"
+	streamEventFacets = "facets"
+)
+
+// A single frame of a streamed search. Exactly one of Result, Error,
+// Facets or Stats is present in each frame.
+type StreamFrame struct {
+	Repo   string                `json:",omitempty"`
+	Result *index.SearchResponse `json:",omitempty"`
+	Error  string                `json:",omitempty"`
+	Facets *Facets               `json:",omitempty"`
+	Stats  *Stats                `json:",omitempty"`
+}
+
+// Writes search frames to the client as they become available, either
+// as newline-delimited JSON or as Server-Sent Events.
+type streamWriter struct {
+	w   http.ResponseWriter
+	f   http.Flusher
+	sse bool
+}
+
+// Does the client prefer Server-Sent Events over newline-delimited JSON?
+func wantsEventStream(r *http.Request) bool {
+	if strings.ToLower(r.FormValue("format")) == "sse" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+func newStreamWriter(w http.ResponseWriter, sse bool) *streamWriter {
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream;charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson;charset=utf-8")
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+
+	f, _ := w.(http.Flusher)
+	return &streamWriter{
+		w:   w,
+		f:   f,
+		sse: sse,
+	}
+}
+
+func (s *streamWriter) write(event string, frame *StreamFrame) error {
+	b, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+
+	if s.sse {
+		b = append([]byte("event: "+event+"\ndata: "), b...)
+		b = append(b, '\n', '\n')
+	} else {
+		b = append(b, '\n')
+	}
+
+	if _, err := s.w.Write(b); err != nil {
+		return err
+	}
+
+	// push the frame out to the client immediately.
+	if s.f != nil {
+		s.f.Flush()
+	}
+
+	return nil
+}
+
+func (s *streamWriter) WriteResult(repo string, res *index.SearchResponse) error {
+	return s.write(streamEventResult, &StreamFrame{
+		Repo:   repo,
+		Result: res,
+	})
+}
+
+func (s *streamWriter) WriteError(err error) error {
+	return s.write(streamEventError, &StreamFrame{
+		Error: err.Error(),
+	})
+}
+
+func (s *streamWriter) WriteFacets(facets *Facets) error {
+	return s.write(streamEventFacets, &StreamFrame{
+		Facets: facets,
+	})
+}
+
+func (s *streamWriter) WriteStats(stats *Stats) error {
+	return s.write(streamEventStats, &StreamFrame{
+		Stats: stats,
+	})
+}
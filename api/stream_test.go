@@ -0,0 +1,175 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var streamRepos = map[string]testRepo{
+	"a": {"main.go": "package main\n\nfunc main() {}\n"},
+	"b": {"lib.go": "package lib\n\nfunc Lib() {}\n"},
+	"c": {"README": "nothing to see\n"},
+}
+
+// Read the frames of an NDJSON stream.
+func ndjsonFrames(t *testing.T, body string) []*StreamFrame {
+	var frames []*StreamFrame
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		var f StreamFrame
+		if err := json.Unmarshal([]byte(line), &f); err != nil {
+			t.Fatalf("bad frame %q: %s", line, err)
+		}
+		frames = append(frames, &f)
+	}
+	return frames
+}
+
+// Read the events of an SSE stream along with their frames.
+func sseFrames(t *testing.T, body string) ([]string, []*StreamFrame) {
+	var events []string
+	var frames []*StreamFrame
+
+	s := bufio.NewScanner(strings.NewReader(body))
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			events = append(events, strings.TrimPrefix(line, "event: "))
+		case strings.HasPrefix(line, "data: "):
+			var f StreamFrame
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &f); err != nil {
+				t.Fatalf("bad frame %q: %s", line, err)
+			}
+			frames = append(frames, &f)
+		case line != "":
+			t.Fatalf("unexpected line %q", line)
+		}
+	}
+
+	if len(events) != len(frames) {
+		t.Fatalf("%d events but %d frames", len(events), len(frames))
+	}
+	return events, frames
+}
+
+// Check that a stream has a result for each of repos, in any order, and
+// ends with the stats.
+func checkResultFrames(t *testing.T, frames []*StreamFrame, repos ...string) {
+	if len(frames) != len(repos)+1 {
+		t.Fatalf("got %d frames, want %d", len(frames), len(repos)+1)
+	}
+
+	seen := map[string]bool{}
+	for _, f := range frames[:len(repos)] {
+		if f.Result == nil || len(f.Result.Matches) == 0 {
+			t.Errorf("frame for %s has no result", f.Repo)
+		}
+		seen[f.Repo] = true
+	}
+	for _, repo := range repos {
+		if !seen[repo] {
+			t.Errorf("no frame for %s", repo)
+		}
+	}
+
+	if last := frames[len(frames)-1]; last.Stats == nil {
+		t.Errorf("stream does not end with the stats: %+v", last)
+	}
+}
+
+func TestStreamNDJSON(t *testing.T) {
+	a := setupAPI(t, streamRepos)
+	defer a.Close()
+
+	rec := a.get("/api/v1/search/stream", url.Values{"q": {"func"}, "repos": {"*"}}, nil)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/x-ndjson") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !rec.Flushed {
+		t.Error("frames were not flushed")
+	}
+
+	checkResultFrames(t, ndjsonFrames(t, rec.Body.String()), "a", "b")
+}
+
+func TestStreamSSE(t *testing.T) {
+	a := setupAPI(t, streamRepos)
+	defer a.Close()
+
+	for _, req := range []struct {
+		values url.Values
+		header http.Header
+	}{
+		{url.Values{"format": {"sse"}}, nil},
+		{url.Values{}, http.Header{"Accept": {"text/event-stream"}}},
+	} {
+		req.values.Set("q", "func")
+		req.values.Set("repos", "*")
+
+		rec := a.get("/api/v1/search/stream", req.values, req.header)
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		if !rec.Flushed {
+			t.Error("events were not flushed")
+		}
+
+		events, frames := sseFrames(t, rec.Body.String())
+		checkResultFrames(t, frames, "a", "b")
+		if want := []string{"result", "result", "stats"}; strings.Join(events, " ") != strings.Join(want, " ") {
+			t.Errorf("events = %v, want %v", events, want)
+		}
+	}
+}
+
+func TestStreamFacets(t *testing.T) {
+	a := setupAPI(t, streamRepos)
+	defer a.Close()
+
+	rec := a.get("/api/v1/search/stream", url.Values{"q": {"func"}, "repos": {"*"}, "facets": {"true"}}, nil)
+	frames := ndjsonFrames(t, rec.Body.String())
+	if len(frames) < 2 {
+		t.Fatalf("got %d frames", len(frames))
+	}
+
+	// the facets come just before the stats.
+	facets := frames[len(frames)-2]
+	checkResultFrames(t, append(frames[:len(frames)-2:len(frames)-2], frames[len(frames)-1]), "a", "b")
+
+	if facets.Facets == nil {
+		t.Fatalf("no facets frame before the stats: %+v", facets)
+	}
+	if len(facets.Facets.Repos) != 2 || facets.Facets.Repos["a"] == nil || facets.Facets.Repos["b"] == nil {
+		t.Errorf("facets for the wrong repos: %v", facets.Facets.Repos)
+	}
+	if ext := facets.Facets.Extensions["go"]; ext == nil || ext.Files != 2 {
+		t.Errorf("expected 2 .go files, got %+v", facets.Facets.Extensions)
+	}
+
+	rec = a.get("/api/v1/search/stream", url.Values{"q": {"func"}, "repos": {"*"}, "facets": {"true"}, "format": {"sse"}}, nil)
+	events, _ := sseFrames(t, rec.Body.String())
+	if want := "result result facets stats"; strings.Join(events, " ") != want {
+		t.Errorf("events = %v, want %s", events, want)
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	a := setupAPI(t, streamRepos)
+	defer a.Close()
+
+	rec := a.get("/api/v1/search/stream", url.Values{"q": {"func("}, "repos": {"*"}}, nil)
+	frames := ndjsonFrames(t, rec.Body.String())
+	if len(frames) != 1 || frames[0].Error == "" {
+		t.Fatalf("want a single error frame, got %+v", frames)
+	}
+
+	rec = a.get("/api/v1/search/stream", url.Values{"q": {"func("}, "repos": {"*"}, "format": {"sse"}}, nil)
+	events, frames := sseFrames(t, rec.Body.String())
+	if len(events) != 1 || events[0] != "error" || frames[0].Error == "" {
+		t.Fatalf("want a single error event, got %v %+v", events, frames)
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/it-projects-llc/hound/index"
+	"github.com/it-projects-llc/hound/searcher"
+)
+
+const (
+	defaultSymbolLimit uint = 100
+	maxSymbolLimit     uint = 1000
+)
+
+// A symbol defined in one of the repos.
+type Symbol struct {
+	Repo string
+	*index.SymbolMatch
+}
+
+type symbolsResponse struct {
+	Symbols   []*Symbol
+	Truncated bool `json:",omitempty"`
+}
+
+// Populate the symbol search options from the form values of the request.
+func parseSymbolSearchOptions(r *http.Request, opt *index.SymbolSearchOptions) {
+	opt.IgnoreCase = parseAsBool(r.FormValue("i"))
+	opt.Kind = r.FormValue("kind")
+	opt.Limit = int(parseAsUintValue(
+		r.FormValue("limit"),
+		0,
+		maxSymbolLimit,
+		defaultSymbolLimit))
+}
+
+// Is a a better match than b? Symbols whose whole name matched come first,
+// as SearchSymbols ranks them, then shorter names.
+func lessSymbol(a, b *Symbol) bool {
+	if a.Exact != b.Exact {
+		return a.Exact
+	}
+	if len(a.Name) != len(b.Name) {
+		return len(a.Name) < len(b.Name)
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	if a.Repo != b.Repo {
+		return a.Repo < b.Repo
+	}
+	if a.Filename != b.Filename {
+		return a.Filename < b.Filename
+	}
+	return a.Line < b.Line
+}
+
+/**
+ * Searches the symbols of all repos in parallel and orders the matches from
+ * all of them together, like searchPaths.
+ */
+func searchSymbols(
+	pat string,
+	opt *index.SymbolSearchOptions,
+	repos []string,
+	idx map[string]*searcher.Searcher) (*symbolsResponse, error) {
+
+	ch := eachRepo(repos, func(repo string) (interface{}, error) {
+		return idx[repo].SearchSymbols(pat, opt)
+	})
+
+	res := &symbolsResponse{}
+	for range repos {
+		rr := <-ch
+		if rr.err != nil {
+			return nil, rr.err
+		}
+		repo, r := rr.repo, rr.res.(*index.SymbolSearchResponse)
+
+		for _, s := range r.Symbols {
+			res.Symbols = append(res.Symbols, &Symbol{repo, s})
+		}
+		res.Truncated = res.Truncated || r.Truncated
+	}
+
+	sort.Slice(res.Symbols, func(i, j int) bool {
+		return lessSymbol(res.Symbols[i], res.Symbols[j])
+	})
+
+	if opt.Limit > 0 && len(res.Symbols) > opt.Limit {
+		res.Symbols = res.Symbols[:opt.Limit]
+		res.Truncated = true
+	}
+
+	return res, nil
+}
@@ -8,15 +8,24 @@ import (
 
 	"github.com/it-projects-llc/hound/ansi"
 	"github.com/it-projects-llc/hound/config"
+	"github.com/it-projects-llc/hound/index"
 )
 
 type ackPresenter struct {
 	f *os.File
 }
 
-func hiliteMatches(c *ansi.Colorer, p *regexp.Regexp, line string) string {
-	// find the indexes for all matches
-	idxs := p.FindAllStringIndex(line, -1)
+func hiliteMatches(c *ansi.Colorer, p *regexp.Regexp, ranges []*index.Range, line string) string {
+	// find the indexes for all matches, preferring the ranges reported by
+	// the server since they come from the same engine that did the search.
+	var idxs [][]int
+	if ranges != nil {
+		for _, r := range ranges {
+			idxs = append(idxs, []int{r.Start, r.End})
+		}
+	} else {
+		idxs = p.FindAllStringIndex(line, -1)
+	}
 
 	var buf bytes.Buffer
 	beg := 0
@@ -64,7 +73,8 @@ func (p *ackPresenter) Present(
 
 	buf := bytes.NewBuffer(make([]byte, 0, 20))
 
-	for repo, resp := range res.Results {
+	for _, repo := range orderedRepos(res) {
+		resp := res.Results[repo]
 		if _, err := fmt.Fprintf(p.f, "%s\n",
 			c.Fg(repoNameFor(repos, repo), ansi.Red, ansi.Bold)); err != nil {
 			return err
@@ -84,7 +94,7 @@ func (p *ackPresenter) Present(
 					hasMatch := block.Matches[i]
 
 					if hasMatch {
-						line = hiliteMatches(c, re, line)
+						line = hiliteMatches(c, re, block.Ranges[i], line)
 					}
 
 					if _, err := fmt.Fprintf(p.f, "%s%s\n",
@@ -2,22 +2,72 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/it-projects-llc/hound/config"
 	"github.com/it-projects-llc/hound/index"
 )
 
+type Stats struct {
+	FilesOpened  int
+	Duration     int
+	FilesCached  int
+	CacheHitRate float64
+}
+
+type Facets struct {
+	Repos      map[string]*index.FacetCount
+	Extensions map[string]*index.FacetCount
+	Dirs       map[string]*index.FacetCount
+}
+
 type Response struct {
-	Results map[string]*index.SearchResponse
-	Stats   *struct {
-		FilesOpened int
-		Duration    int
-	} `json:",omitempty"`
+	Results   map[string]*index.SearchResponse
+	Stats     *Stats              `json:",omitempty"`
+	Facets    *Facets             `json:",omitempty"`
+	Truncated bool                `json:",omitempty"`
+	Reason    string              `json:",omitempty"`
+	Cursor    string              `json:",omitempty"`
+	Expansion map[string][]string `json:",omitempty"`
+}
+
+// A path matched by the path search API.
+type PathMatch struct {
+	Repo string
+	*index.PathMatch
+}
+
+type PathsResponse struct {
+	Matches   []*PathMatch
+	Truncated bool   `json:",omitempty"`
+	Error     string `json:",omitempty"`
+}
+
+type Symbol struct {
+	Repo string
+	*index.SymbolMatch
+}
+
+type SymbolsResponse struct {
+	Symbols   []*Symbol
+	Truncated bool   `json:",omitempty"`
+	Error     string `json:",omitempty"`
+}
+
+// A single frame from the streaming search API.
+type StreamFrame struct {
+	Repo   string
+	Result *index.SearchResponse
+	Error  string
+	Facets *Facets
+	Stats  *Stats
 }
 
 type Presenter interface {
@@ -31,6 +81,36 @@ type Presenter interface {
 type Config struct {
 	HttpHeaders map[string]string `json:"http-headers"`
 	Host        string            `json:"host"`
+
+	// Send search patterns as queries with operators, see package query.
+	Query bool `json:"query"`
+}
+
+// The score of the best file in a response, files in ranked responses are
+// sorted best first.
+func topScore(res *index.SearchResponse) float64 {
+	if len(res.Matches) == 0 {
+		return 0
+	}
+	return res.Matches[0].Score
+}
+
+// Order the repos of a response by the score of their best file and then
+// by name, which is just by name unless the search was ranked.
+func orderedRepos(res *Response) []string {
+	repos := make([]string, 0, len(res.Results))
+	for repo := range res.Results {
+		repos = append(repos, repo)
+	}
+
+	sort.Slice(repos, func(i, j int) bool {
+		a, b := topScore(res.Results[repos[i]]), topScore(res.Results[repos[j]])
+		if a != b {
+			return a > b
+		}
+		return repos[i] < repos[j]
+	})
+	return repos
 }
 
 // Extract a repo name from the given url.
@@ -87,17 +167,146 @@ func doHttpGet(cfg *Config, uri string) (*http.Response, error) {
 	return c.Do(req)
 }
 
+// Build the url for the search API at the given path on host.
+func searchUrl(cfg *Config, path, pattern, repos string, opt *index.SearchOptions, stats bool) string {
+	return fmt.Sprintf("http://%s%s?%s",
+		cfg.Host,
+		path,
+		url.Values{
+			"q":            {pattern},
+			"repos":        {repos},
+			"files":        {opt.FileRegexp},
+			"excludeFiles": {opt.ExcludeFileRegexp},
+			"langs":        {strings.Join(opt.Languages, ",")},
+			"ctx":          {fmt.Sprintf("%d", opt.LinesOfContext)},
+			"i":            {fmt.Sprintf("%t", opt.IgnoreCase)},
+			"literal":      {fmt.Sprintf("%t", opt.Literal)},
+			"word":         {fmt.Sprintf("%t", opt.WholeWord)},
+			"multiline":    {fmt.Sprintf("%t", opt.Multiline)},
+			"facets":       {fmt.Sprintf("%t", opt.Facets)},
+			"facetdepth":   {fmt.Sprintf("%d", opt.FacetDepth)},
+			"countonly":    {fmt.Sprintf("%t", opt.CountOnly)},
+			"rank":         {fmt.Sprintf("%t", opt.Rank)},
+			"ident":        {fmt.Sprintf("%t", opt.IdentStyle)},
+			"defs":         {fmt.Sprintf("%t", opt.DefinitionsOnly)},
+			"stats":        {fmt.Sprintf("%t", stats)},
+			"syntax":       {syntaxFor(cfg)},
+		}.Encode())
+}
+
+// The syntax of the search patterns sent to the API.
+func syntaxFor(cfg *Config) string {
+	if cfg.Query {
+		return "query"
+	}
+	return ""
+}
+
 // Executes a search on the API running on host.
-func Search(r *Response, cfg *Config, pattern, repos, files string, context int, ignoreCase, stats bool) error {
-	u := fmt.Sprintf("http://%s/api/v1/search?%s",
+func Search(r *Response, cfg *Config, pattern, repos string, opt *index.SearchOptions, stats bool) error {
+	u := searchUrl(cfg, "/api/v1/search", pattern, repos, opt, stats)
+
+	res, err := doHttpGet(cfg, u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Status %d", res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(r)
+}
+
+// Executes a streaming search on the API running on host. The given function
+// is called with the results for each repo as soon as the server delivers them.
+// The summary stats, and the facets when they were asked for, are stored
+// in r once the stream completes.
+func SearchStream(r *Response, cfg *Config, pattern, repos string, opt *index.SearchOptions,
+	fn func(repo string, res *index.SearchResponse) error) error {
+	u := searchUrl(cfg, "/api/v1/search/stream", pattern, repos, opt, true)
+
+	res, err := doHttpGet(cfg, u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Status %d", res.StatusCode)
+	}
+
+	dec := json.NewDecoder(res.Body)
+	for {
+		var frame StreamFrame
+		if err := dec.Decode(&frame); err == io.EOF {
+			return errors.New("search stream ended unexpectedly")
+		} else if err != nil {
+			return err
+		}
+
+		switch {
+		case frame.Error != "":
+			return errors.New(frame.Error)
+		case frame.Facets != nil:
+			r.Facets = frame.Facets
+		case frame.Stats != nil:
+			r.Stats = frame.Stats
+			return nil
+		case frame.Result != nil:
+			if err := fn(frame.Repo, frame.Result); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Searches the paths of the indexed files on the API running on host.
+func SearchPaths(r *PathsResponse, cfg *Config, pattern, repos string, opt *index.PathSearchOptions) error {
+	u := fmt.Sprintf("http://%s/api/v1/files?%s",
+		cfg.Host,
+		url.Values{
+			"q":        {pattern},
+			"repos":    {repos},
+			"i":        {fmt.Sprintf("%t", opt.IgnoreCase)},
+			"literal":  {fmt.Sprintf("%t", opt.Literal)},
+			"excluded": {fmt.Sprintf("%t", opt.IncludeExcluded)},
+			"limit":    {fmt.Sprintf("%d", opt.Limit)},
+		}.Encode())
+
+	res, err := doHttpGet(cfg, u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Status %d", res.StatusCode)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(r); err != nil {
+		return err
+	}
+
+	if r.Error != "" {
+		return errors.New(r.Error)
+	}
+
+	return nil
+}
+
+// Searches the symbols defined in the indexed files on the API running on
+// host.
+func SearchSymbols(r *SymbolsResponse, cfg *Config, pattern, repos string, opt *index.SymbolSearchOptions) error {
+	u := fmt.Sprintf("http://%s/api/v1/symbols?%s",
 		cfg.Host,
 		url.Values{
 			"q":     {pattern},
 			"repos": {repos},
-			"files": {files},
-			"ctx":   {fmt.Sprintf("%d", context)},
-			"i":     {fmt.Sprintf("%t", ignoreCase)},
-			"stats": {fmt.Sprintf("%t", stats)},
+			"i":     {fmt.Sprintf("%t", opt.IgnoreCase)},
+			"kind":  {opt.Kind},
+			"limit": {fmt.Sprintf("%d", opt.Limit)},
 		}.Encode())
 
 	res, err := doHttpGet(cfg, u)
@@ -110,6 +319,41 @@ func Search(r *Response, cfg *Config, pattern, repos, files string, context int,
 		return fmt.Errorf("Status %d", res.StatusCode)
 	}
 
+	if err := json.NewDecoder(res.Body).Decode(r); err != nil {
+		return err
+	}
+
+	if r.Error != "" {
+		return errors.New(r.Error)
+	}
+
+	return nil
+}
+
+// Reads lines from through to of a file in repo from the API running on
+// host. A zero to reads through the end of the file.
+func ReadFile(r *index.FileResponse, cfg *Config, repo, path string, from, to int) error {
+	u := fmt.Sprintf("http://%s/api/v1/file?%s",
+		cfg.Host,
+		url.Values{
+			"repo": {repo},
+			"path": {path},
+			"from": {fmt.Sprintf("%d", from)},
+			"to":   {fmt.Sprintf("%d", to)},
+		}.Encode())
+
+	res, err := doHttpGet(cfg, u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("No such file: %s in %s", path, repo)
+	} else if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Status %d", res.StatusCode)
+	}
+
 	return json.NewDecoder(res.Body).Decode(r)
 }
 
@@ -125,11 +369,11 @@ func LoadRepos(repos map[string]*config.Repo, cfg *Config) error {
 }
 
 // Execute a search and load the list of repositories in parallel on the host.
-func SearchAndLoadRepos(cfg *Config, pattern, repos, files string, context int, ignoreCase, stats bool) (*Response, map[string]*config.Repo, error) {
+func SearchAndLoadRepos(cfg *Config, pattern, repos string, opt *index.SearchOptions, stats bool) (*Response, map[string]*config.Repo, error) {
 	chs := make(chan error)
 	var res Response
 	go func() {
-		chs <- Search(&res, cfg, pattern, repos, files, context, ignoreCase, stats)
+		chs <- Search(&res, cfg, pattern, repos, opt, stats)
 	}()
 
 	chr := make(chan error)
@@ -8,6 +8,10 @@ type Block struct {
 	Lines   []string
 	Matches []bool
 	Start   int
+
+	// The ranges of the matches on each line, nil for lines without
+	// matches or when the server did not report ranges.
+	Ranges [][]*index.Range
 }
 
 func endOfBlock(b *Block) int {
@@ -22,19 +26,39 @@ func matchIsInBlock(m *index.Match, b *Block) bool {
 	return startOfMatch(m) <= endOfBlock(b)
 }
 
+// The lines of the match itself, which may be several for matches that
+// span lines.
+func matchLines(m *index.Match) []string {
+	if len(m.Lines) > 0 {
+		return m.Lines
+	}
+	return []string{m.Line}
+}
+
 func matchToBlock(m *index.Match) *Block {
+	ml := matchLines(m)
 	b, a := len(m.Before), len(m.After)
-	n := 1 + b + a
+	n := len(ml) + b + a
 	l := make([]string, 0, n)
 	v := make([]bool, n)
+	r := make([][]*index.Range, n)
 
-	v[b] = true
+	for i := range ml {
+		v[b+i] = true
+	}
+
+	// ranges are only reported for matches on a single line.
+	if len(ml) == 1 {
+		r[b] = m.Ranges
+	}
 
 	for _, line := range m.Before {
 		l = append(l, line)
 	}
 
-	l = append(l, m.Line)
+	for _, line := range ml {
+		l = append(l, line)
+	}
 
 	for _, line := range m.After {
 		l = append(l, line)
@@ -44,36 +68,31 @@ func matchToBlock(m *index.Match) *Block {
 		Lines:   l,
 		Matches: v,
 		Start:   m.LineNumber - len(m.Before),
+		Ranges:  r,
 	}
 }
 
-func clampZero(n int) int {
-	if n < 0 {
-		return 0
-	}
-	return n
-}
-
 func mergeMatchIntoBlock(m *index.Match, b *Block) {
-	off := endOfBlock(b) - startOfMatch(m) + 1
-	idx := len(b.Lines) - off
-	nb := len(m.Before)
-
-	for i := off; i < nb; i++ {
-		b.Lines = append(b.Lines, m.Before[i])
-		b.Matches = append(b.Matches, false)
-	}
-
-	if off < nb+1 {
-		b.Lines = append(b.Lines, m.Line)
-		b.Matches = append(b.Matches, true)
-	} else {
-		b.Matches[idx+nb] = true
-	}
+	mb := matchToBlock(m)
+	end := endOfBlock(b)
+
+	for i, line := range mb.Lines {
+		num := mb.Start + i
+
+		// lines past the end of the block are simply appended ...
+		if num > end {
+			b.Lines = append(b.Lines, line)
+			b.Matches = append(b.Matches, mb.Matches[i])
+			b.Ranges = append(b.Ranges, mb.Ranges[i])
+			continue
+		}
 
-	for i, n := clampZero(off-nb-1), len(m.After); i < n; i++ {
-		b.Lines = append(b.Lines, m.After[i])
-		b.Matches = append(b.Matches, false)
+		// ... while lines already in the block only need to be marked
+		// if they are part of the match.
+		if mb.Matches[i] {
+			b.Matches[num-b.Start] = true
+			b.Ranges[num-b.Start] = mb.Ranges[i]
+		}
 	}
 }
 
@@ -258,3 +258,80 @@ func TestMatchesToEnd(t *testing.T) {
 	testThis(t, subj, expt,
 		"test matches at end of file")
 }
+
+func TestRangesFollowMatches(t *testing.T) {
+	ra := []*index.Range{{Start: 0, End: 1, RuneStart: 0, RuneEnd: 1}}
+	rb := []*index.Range{{Start: 1, End: 2, RuneStart: 1, RuneEnd: 2}}
+	subj := []*index.Match{
+		&index.Match{
+			Line:       "c",
+			LineNumber: 40,
+			Ranges:     ra,
+			Before:     []string{"a", "b"},
+			After:      []string{"d", "e"},
+		},
+		&index.Match{
+			Line:       "dd",
+			LineNumber: 41,
+			Ranges:     rb,
+			Before:     []string{"b", "c"},
+			After:      []string{"e", "f"},
+		},
+	}
+
+	blocks := coalesceMatches(subj)
+	if len(blocks) != 1 {
+		t.Fatalf("expected 1 block, got %d", len(blocks))
+	}
+
+	b := blocks[0]
+	if len(b.Ranges) != len(b.Lines) {
+		t.Fatalf("expected %d ranges, got %d", len(b.Lines), len(b.Ranges))
+	}
+
+	for i, n := 0, len(b.Lines); i < n; i++ {
+		var expected []*index.Range
+		switch i {
+		case 2:
+			expected = ra
+		case 3:
+			expected = rb
+		}
+
+		if len(b.Ranges[i]) != len(expected) || (expected != nil && b.Ranges[i][0] != expected[0]) {
+			t.Errorf("bad ranges on line %d: expected %v, got %v", i, expected, b.Ranges[i])
+		}
+	}
+}
+
+func TestMultilineMatches(t *testing.T) {
+	subj := []*index.Match{
+		&index.Match{
+			Line:          "c",
+			LineNumber:    40,
+			Lines:         []string{"c", "d", "e"},
+			EndLineNumber: 42,
+			Before:        []string{"a", "b"},
+			After:         []string{"f", "g"},
+		},
+		&index.Match{
+			Line:          "g",
+			LineNumber:    44,
+			Lines:         []string{"g", "h"},
+			EndLineNumber: 45,
+			Before:        []string{"e", "f"},
+			After:         []string{"i"},
+		},
+	}
+
+	expt := []*Block{
+		&Block{
+			Lines:   []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"},
+			Matches: []bool{false, false, true, true, true, false, true, true, false},
+			Start:   38,
+		},
+	}
+
+	testThis(t, subj, expt,
+		"multiline matches")
+}
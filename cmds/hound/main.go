@@ -3,13 +3,18 @@ package main
 import (
 	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/user"
 	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/it-projects-llc/hound/client"
+	"github.com/it-projects-llc/hound/config"
 	"github.com/it-projects-llc/hound/index"
+	"github.com/it-projects-llc/hound/query"
 )
 
 // A uninitialized variable that can be defined during the build process with
@@ -84,10 +89,38 @@ func main() {
 	flagHost := flag.String("host", defaultFlagForHost(), "")
 	flagRepos := flag.String("repos", "*", "")
 	flagFiles := flag.String("files", "", "")
+	flagExcludeFiles := flag.String("exclude-files", "", "")
+	flagLangs := flag.String("langs", "", "")
 	flagContext := flag.Int("context", 2, "")
 	flagCase := flag.Bool("ignore-case", false, "")
 	flagStats := flag.Bool("show-stats", false, "")
 	flagGrep := flag.Bool("like-grep", false, "")
+	flagStream := flag.Bool("stream", false, "")
+	flagPaths := flag.Bool("paths", false, "")
+	flagExcluded := flag.Bool("excluded", false, "")
+	flagLimit := flag.Int("limit", 100, "")
+	flagCount := flag.Bool("count", false, "")
+	flagRank := flag.Bool("rank", false, "")
+	flagIdent := flag.Bool("ident", false, "")
+	flagSymbols := flag.Bool("symbols", false, "")
+	flagKind := flag.String("kind", "", "")
+	flagDefs := flag.Bool("defs", false, "")
+	flagQuery := flag.Bool("query", false, "")
+	flagShow := flag.Bool("show", false, "")
+	flagFrom := flag.Int("from", 0, "")
+	flagTo := flag.Int("to", 0, "")
+
+	var flagLiteral bool
+	flag.BoolVar(&flagLiteral, "literal", false, "")
+	flag.BoolVar(&flagLiteral, "F", false, "")
+
+	var flagWord bool
+	flag.BoolVar(&flagWord, "whole-word", false, "")
+	flag.BoolVar(&flagWord, "w", false, "")
+
+	var flagMultiline bool
+	flag.BoolVar(&flagMultiline, "multiline", false, "")
+	flag.BoolVar(&flagMultiline, "U", false, "")
 
 	flag.Parse()
 
@@ -96,7 +129,75 @@ func main() {
 		return
 	}
 
-	pat := index.GetRegexpPattern(flag.Arg(0), *flagCase)
+	cfg := client.Config{
+		Host:        *flagHost,
+		HttpHeaders: nil,
+		Query:       *flagQuery,
+	}
+
+	if err := loadConfig(&cfg); err != nil {
+		log.Panic(err)
+	}
+
+	if *flagShow {
+		if err := showFile(&cfg, flag.Arg(0), *flagFrom, *flagTo); err != nil {
+			log.Panic(err)
+		}
+		return
+	}
+
+	if *flagPaths {
+		if err := searchPaths(&cfg, flag.Arg(0), *flagRepos, &index.PathSearchOptions{
+			IgnoreCase:      *flagCase,
+			Literal:         flagLiteral,
+			IncludeExcluded: *flagExcluded,
+			Limit:           *flagLimit,
+		}); err != nil {
+			log.Panic(err)
+		}
+		return
+	}
+
+	if *flagSymbols {
+		if err := searchSymbols(&cfg, flag.Arg(0), *flagRepos, &index.SymbolSearchOptions{
+			IgnoreCase: *flagCase,
+			Kind:       *flagKind,
+			Limit:      *flagLimit,
+		}); err != nil {
+			log.Panic(err)
+		}
+		return
+	}
+
+	opt := index.SearchOptions{
+		IgnoreCase:        *flagCase,
+		Literal:           flagLiteral,
+		WholeWord:         flagWord,
+		Multiline:         flagMultiline,
+		LinesOfContext:    uint(*flagContext),
+		FileRegexp:        *flagFiles,
+		ExcludeFileRegexp: *flagExcludeFiles,
+		Languages:         splitLanguages(*flagLangs),
+		CountOnly:         *flagCount,
+		Rank:              *flagRank,
+		IdentStyle:        *flagIdent,
+		DefinitionsOnly:   *flagDefs,
+	}
+
+	// The query is sent to the server as is, it is only parsed here to find
+	// the patterns and case sensitivity to highlight the results with.
+	q := query.Raw(flag.Arg(0))
+	if cfg.Query {
+		var err error
+		q, err = query.Parse(flag.Arg(0))
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	hopt := opt
+	q.Apply(&hopt)
+	pat := index.GetRegexpPatternForClauses(q.Clauses, &hopt)
 
 	reg, err := regexp.Compile(pat)
 	if err != nil {
@@ -104,21 +205,24 @@ func main() {
 		log.Panic(err)
 	}
 
-	cfg := client.Config{
-		Host:        *flagHost,
-		HttpHeaders: nil,
+	if *flagCount {
+		if err := countResults(&cfg, *flagRepos, &opt); err != nil {
+			log.Panic(err)
+		}
+		return
 	}
 
-	if err := loadConfig(&cfg); err != nil {
-		log.Panic(err)
+	if *flagStream {
+		if err := streamResults(&cfg, reg, *flagRepos, &opt, *flagGrep); err != nil {
+			log.Panic(err)
+		}
+		return
 	}
 
 	res, repos, err := client.SearchAndLoadRepos(&cfg,
 		flag.Arg(0),
 		*flagRepos,
-		*flagFiles,
-		*flagContext,
-		*flagCase,
+		&opt,
 		*flagStats)
 	if err != nil {
 		log.Panic(err)
@@ -127,4 +231,129 @@ func main() {
 	if err := newPresenter(*flagGrep).Present(reg, *flagContext, repos, res); err != nil {
 		log.Panic(err)
 	}
+
+	if res.Truncated {
+		fmt.Fprintf(os.Stderr, "Results are incomplete: %s\n", res.Reason)
+	}
+}
+
+// Search the paths of the indexed files, printing each match as repo:path.
+func searchPaths(cfg *client.Config, pat, repos string, opt *index.PathSearchOptions) error {
+	var res client.PathsResponse
+	if err := client.SearchPaths(&res, cfg, pat, repos, opt); err != nil {
+		return err
+	}
+
+	for _, m := range res.Matches {
+		if m.Excluded != "" {
+			fmt.Printf("%s:%s (%s)\n", m.Repo, m.Filename, m.Excluded)
+		} else {
+			fmt.Printf("%s:%s\n", m.Repo, m.Filename)
+		}
+	}
+
+	if res.Truncated {
+		fmt.Fprintf(os.Stderr, "Results are incomplete: more than %d paths matched\n", opt.Limit)
+	}
+
+	return nil
+}
+
+// Split the comma separated value of -langs.
+func splitLanguages(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+// Search the symbols of the indexed files, printing each one as
+// repo:path:line followed by its kind and name.
+func searchSymbols(cfg *client.Config, pat, repos string, opt *index.SymbolSearchOptions) error {
+	var res client.SymbolsResponse
+	if err := client.SearchSymbols(&res, cfg, pat, repos, opt); err != nil {
+		return err
+	}
+
+	for _, s := range res.Symbols {
+		name := s.Name
+		if s.Scope != "" {
+			name = s.Scope + "." + s.Name
+		}
+		fmt.Printf("%s:%s:%d: %s %s\n", s.Repo, s.Filename, s.Line, s.Kind, name)
+	}
+
+	if res.Truncated {
+		fmt.Fprintf(os.Stderr, "Results are incomplete: more than %d symbols matched\n", opt.Limit)
+	}
+
+	return nil
+}
+
+// Print lines of a file named as repo:path, the way -paths prints them,
+// preceded by their line numbers.
+func showFile(cfg *client.Config, name string, from, to int) error {
+	ix := strings.Index(name, ":")
+	if ix < 0 {
+		return fmt.Errorf("Expected repo:path, got %s", name)
+	}
+
+	var res index.FileResponse
+	if err := client.ReadFile(&res, cfg, name[:ix], name[ix+1:], from, to); err != nil {
+		return err
+	}
+
+	for i, line := range res.Lines {
+		fmt.Printf("%d:%s\n", res.From+i, line)
+	}
+
+	return nil
+}
+
+// Count the matches in each repo without fetching any of them.
+func countResults(cfg *client.Config, repos string, opt *index.SearchOptions) error {
+	var res client.Response
+	if err := client.Search(&res, cfg, flag.Arg(0), repos, opt, false); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(res.Results))
+	for name := range res.Results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var files, lines int
+	for _, name := range names {
+		r := res.Results[name]
+		fmt.Printf("%s: %d matches in %d files\n", name, r.LinesWithMatch, r.FilesWithMatch)
+		files += r.FilesWithMatch
+		lines += r.LinesWithMatch
+	}
+	fmt.Printf("total: %d matches in %d files\n", lines, files)
+
+	if res.Truncated {
+		fmt.Fprintf(os.Stderr, "Results are incomplete: %s\n", res.Reason)
+	}
+
+	return nil
+}
+
+// Search using the streaming API, presenting the results for each repo as
+// soon as they arrive.
+func streamResults(cfg *client.Config, reg *regexp.Regexp, repos string, opt *index.SearchOptions, likeGrep bool) error {
+	rep := map[string]*config.Repo{}
+	if err := client.LoadRepos(rep, cfg); err != nil {
+		return err
+	}
+
+	p := newPresenter(likeGrep)
+
+	var res client.Response
+	return client.SearchStream(&res, cfg, flag.Arg(0), repos, opt,
+		func(repo string, r *index.SearchResponse) error {
+			return p.Present(reg, int(opt.LinesOfContext), rep, &client.Response{
+				Results: map[string]*index.SearchResponse{repo: r},
+			})
+		})
 }
@@ -109,7 +109,7 @@ func runHttp(
 	}
 
 	m.Handle("/", h)
-	api.Setup(m, idx)
+	api.Setup(m, cfg, idx)
 	return http.ListenAndServe(addr, m)
 }
 
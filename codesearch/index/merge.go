@@ -32,10 +32,12 @@ package index
 
 import (
   "encoding/binary"
-  "os"
+  "errors"
   "strings"
 )
 
+var errInconsistent = errors.New("merge: inconsistent index")
+
 // An idrange records that the half-open interval [lo, hi) maps to [new, new+hi-lo).
 type idrange struct {
   lo, hi, new uint32
@@ -50,11 +52,22 @@ type postIndex struct {
 // Merge creates a new index in the file dst that corresponds to merging
 // the two indices src1 and src2.  If both src1 and src2 claim responsibility
 // for a path, src2 is assumed to be newer and is given preference.
-func Merge(dst, src1, src2 string) {
-  ix1 := Open(src1)
-  ix2 := Open(src2)
-  paths1 := ix1.Paths()
-  paths2 := ix2.Paths()
+// Merge fails if either index cannot be opened or is damaged, if they
+// disagree about their files or if dst cannot be written.
+func Merge(dst, src1, src2 string) (err error) {
+  defer recoverCorrupt(&err)
+  ix1, err := Open(src1)
+  if err != nil {
+    return err
+  }
+  defer ix1.Close()
+  ix2, err := Open(src2)
+  if err != nil {
+    return err
+  }
+  defer ix2.Close()
+  paths1 := ix1.paths()
+  paths2 := ix2.paths()
 
   // Build docid maps.
   var i1, i2, new uint32
@@ -62,12 +75,12 @@ func Merge(dst, src1, src2 string) {
   for _, path := range paths2 {
     // Determine range shadowed by this path.
     old := i1
-    for i1 < uint32(ix1.numName) && ix1.Name(i1) < path {
+    for i1 < uint32(ix1.numName) && ix1.name(i1) < path {
       i1++
     }
     lo := i1
-    limit := path[:len(path)-1] + string(path[len(path)-1]+1)
-    for i1 < uint32(ix1.numName) && ix1.Name(i1) < limit {
+    limit, bounded := prefixLimit(path)
+    for i1 < uint32(ix1.numName) && (!bounded || ix1.name(i1) < limit) {
       i1++
     }
     hi := i1
@@ -81,11 +94,11 @@ func Merge(dst, src1, src2 string) {
     // Determine range defined by this path.
     // Because we are iterating over the ix2 paths,
     // there can't be gaps, so it must start at i2.
-    if i2 < uint32(ix2.numName) && ix2.Name(i2) < path {
-      panic("merge: inconsistent index")
+    if i2 < uint32(ix2.numName) && ix2.name(i2) < path {
+      return errInconsistent
     }
     lo = i2
-    for i2 < uint32(ix2.numName) && ix2.Name(i2) < limit {
+    for i2 < uint32(ix2.numName) && (!bounded || ix2.name(i2) < limit) {
       i2++
     }
     hi = i2
@@ -100,11 +113,12 @@ func Merge(dst, src1, src2 string) {
     new += uint32(ix1.numName) - i1
   }
   if i2 < uint32(ix2.numName) {
-    panic("merge: inconsistent index")
+    return errInconsistent
   }
   numName := new
 
   ix3 := bufCreate(dst)
+  defer ix3.close()
   ix3.writeString(magic)
 
   // Merged list of paths.
@@ -133,13 +147,14 @@ func Merge(dst, src1, src2 string) {
   // Merged list of names.
   nameData := ix3.offset()
   nameIndexFile := bufCreate("")
+  defer nameIndexFile.remove()
   new = 0
   mi1 = 0
   mi2 = 0
   for new < numName {
     if mi1 < len(map1) && map1[mi1].new == new {
       for i := map1[mi1].lo; i < map1[mi1].hi; i++ {
-        name := ix1.Name(i)
+        name := ix1.name(i)
         nameIndexFile.writeUint32(ix3.offset() - nameData)
         ix3.writeString(name)
         ix3.writeString("\x00")
@@ -148,7 +163,7 @@ func Merge(dst, src1, src2 string) {
       mi1++
     } else if mi2 < len(map2) && map2[mi2].new == new {
       for i := map2[mi2].lo; i < map2[mi2].hi; i++ {
-        name := ix2.Name(i)
+        name := ix2.name(i)
         nameIndexFile.writeUint32(ix3.offset() - nameData)
         ix3.writeString(name)
         ix3.writeString("\x00")
@@ -156,11 +171,11 @@ func Merge(dst, src1, src2 string) {
       }
       mi2++
     } else {
-      panic("merge: inconsistent index")
+      return errInconsistent
     }
   }
   if new*4 != nameIndexFile.offset() {
-    panic("merge: inconsistent index")
+    return errInconsistent
   }
   nameIndexFile.writeUint32(ix3.offset())
 
@@ -172,6 +187,7 @@ func Merge(dst, src1, src2 string) {
   r1.init(ix1, map1)
   r2.init(ix2, map2)
   w.init(ix3)
+  defer w.postIndexFile.remove()
   for {
     if r1.trigram < r2.trigram {
       w.trigram(r1.trigram)
@@ -202,7 +218,7 @@ func Merge(dst, src1, src2 string) {
           w.fileid(r2.fileid)
           r2.nextId()
         } else {
-          panic("merge: inconsistent index")
+          return errInconsistent
         }
       }
       r1.nextTrigram()
@@ -227,8 +243,21 @@ func Merge(dst, src1, src2 string) {
   ix3.writeString(trailerMagic)
   ix3.flush()
 
-  os.Remove(nameIndexFile.name)
-  os.Remove(w.postIndexFile.name)
+  // copyFile passes on the errors of the temporary files.
+  return ix3.err
+}
+
+// prefixLimit returns the smallest string greater than every string
+// that starts with path. There is none when path is all 0xFF bytes.
+func prefixLimit(path string) (string, bool) {
+  b := []byte(path)
+  for i := len(b) - 1; i >= 0; i-- {
+    if b[i] < 0xFF {
+      b[i]++
+      return string(b[:i+1]), true
+    }
+  }
+  return "", false
 }
 
 type postMapReader struct {
@@ -7,6 +7,7 @@ package index
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -52,11 +53,13 @@ func TestMerge(t *testing.T) {
 	buildIndex(out1, mergePaths1, mergeFiles1)
 	buildIndex(out2, mergePaths2, mergeFiles2)
 
-	Merge(out3, out1, out2)
+	if err := Merge(out3, out1, out2); err != nil {
+		t.Fatal(err)
+	}
 
-	ix1 := Open(out1)
-	ix2 := Open(out2)
-	ix3 := Open(out3)
+	ix1 := mustOpen(t, out1)
+	ix2 := mustOpen(t, out2)
+	ix3 := mustOpen(t, out3)
 
 	nameof := func(ix *Index) string {
 		switch {
@@ -72,7 +75,7 @@ func TestMerge(t *testing.T) {
 
 	checkFiles := func(ix *Index, l ...string) {
 		for i, s := range l {
-			if n := ix.Name(uint32(i)); n != s {
+			if n := ix.name(uint32(i)); n != s {
 				t.Errorf("%s: Name(%d) = %s, want %s", nameof(ix), i, n, s)
 			}
 		}
@@ -83,7 +86,7 @@ func TestMerge(t *testing.T) {
 	checkFiles(ix3, "/a/x", "/a/y", "/b/www", "/b/xx", "/b/yy", "/c/ab", "/c/de", "/cc")
 
 	check := func(ix *Index, trig string, l ...uint32) {
-		l1 := ix.PostingList(tri(trig[0], trig[1], trig[2]))
+		l1 := ix.postingList(tri(trig[0], trig[1], trig[2]), nil)
 		if !equalList(l1, l) {
 			t.Errorf("PostingList(%s, %s) = %v, want %v", nameof(ix), trig, l1, l)
 		}
@@ -100,3 +103,48 @@ func TestMerge(t *testing.T) {
 	check(ix3, "now", 3, 4, 6)
 	check(ix3, "pot", 4, 5, 7)
 }
+
+func TestPrefixLimit(t *testing.T) {
+	tests := []struct {
+		path    string
+		limit   string
+		bounded bool
+	}{
+		{"/a", "/b", true},
+		{"/\x7f", "/\x80", true},
+		{"/é", "/\xc3\xaa", true},
+		{"/a\xff", "/b", true},
+		{"\xff\xff", "", false},
+	}
+
+	for _, test := range tests {
+		limit, bounded := prefixLimit(test.path)
+		if limit != test.limit || bounded != test.bounded {
+			t.Errorf("prefixLimit(%q) = %q, %t, want %q, %t", test.path, limit, bounded, test.limit, test.bounded)
+		}
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	f1, _ := ioutil.TempFile("", "index-test")
+	f2, _ := ioutil.TempFile("", "index-test")
+	defer os.Remove(f1.Name())
+	defer os.Remove(f2.Name())
+
+	buildIndex(f1.Name(), mergePaths1, mergeFiles1)
+	buildIndex(f2.Name(), mergePaths2, mergeFiles2)
+
+	dir, err := ioutil.TempDir("", "index-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := Merge(filepath.Join(dir, "out"), f1.Name(), filepath.Join(dir, "missing")); err == nil {
+		t.Error("merged a missing index")
+	}
+
+	if err := Merge(filepath.Join(dir, "no", "out"), f1.Name(), f2.Name()); err == nil {
+		t.Error("merged into a directory that does not exist")
+	}
+}
@@ -7,29 +7,29 @@
 package index
 
 import (
-  "log"
+  "fmt"
   "os"
   "syscall"
 )
 
-func mmapFile(f *os.File) mmapData {
+func mmapFile(f *os.File) (mmapData, error) {
   st, err := f.Stat()
   if err != nil {
-    log.Fatal(err)
+    return mmapData{}, err
   }
   size := st.Size()
   if int64(int(size+4095)) != size+4095 {
-    log.Fatalf("%s: too large for mmap", f.Name())
+    return mmapData{}, fmt.Errorf("%s: too large for mmap", f.Name())
   }
   n := int(size)
   if n == 0 {
-    return mmapData{f, nil, nil}
+    return mmapData{f, nil, nil}, nil
   }
   data, err := syscall.Mmap(int(f.Fd()), 0, (n+4095)&^4095, syscall.PROT_READ, syscall.MAP_PRIVATE)
   if err != nil {
-    log.Fatalf("mmap %s: %v", f.Name(), err)
+    return mmapData{}, fmt.Errorf("mmap %s: %v", f.Name(), err)
   }
-  return mmapData{f, data[:n], data}
+  return mmapData{f, data[:n], data}, nil
 }
 
 func unmmapFile(m *mmapData) error {
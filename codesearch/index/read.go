@@ -67,6 +67,7 @@ package index
 import (
   "bytes"
   "encoding/binary"
+  "fmt"
   "log"
   "os"
   "runtime"
@@ -93,21 +94,55 @@ type Index struct {
 
 const postEntrySize = 3 + 4 + 4
 
-func Open(file string) *Index {
-  mm := mmap(file)
-  if len(mm.d) < 4*4+len(trailerMagic) || string(mm.d[len(mm.d)-len(trailerMagic):]) != trailerMagic {
-    corrupt(mm.f)
+// Open opens the index in file. It fails when the file cannot be mapped or
+// its header, trailer or section offsets are damaged, as they are when the
+// file is truncated.
+func Open(file string) (*Index, error) {
+  mm, err := mmap(file)
+  if err != nil {
+    return nil, err
+  }
+  ix, err := openData(mm)
+  if err != nil {
+    // an empty file has nothing mapped.
+    if mm.o != nil {
+      unmmap(mm.o)
+    }
+    mm.f.Close()
+    return nil, err
   }
-  n := uint32(len(mm.d) - len(trailerMagic) - 5*4)
+  return ix, nil
+}
+
+func openData(mm mmapData) (*Index, error) {
+  d := mm.d
+  if len(d) < len(magic)+5*4+len(trailerMagic) ||
+    string(d[:len(magic)]) != magic ||
+    string(d[len(d)-len(trailerMagic):]) != trailerMagic {
+    return nil, corruptError(mm.f)
+  }
+  n := uint32(len(d) - len(trailerMagic) - 5*4)
   ix := &Index{data: mm}
-  ix.pathData = ix.uint32(n)
-  ix.nameData = ix.uint32(n + 4)
-  ix.postData = ix.uint32(n + 8)
-  ix.nameIndex = ix.uint32(n + 12)
-  ix.postIndex = ix.uint32(n + 16)
+  ix.pathData = binary.BigEndian.Uint32(d[n:])
+  ix.nameData = binary.BigEndian.Uint32(d[n+4:])
+  ix.postData = binary.BigEndian.Uint32(d[n+8:])
+  ix.nameIndex = binary.BigEndian.Uint32(d[n+12:])
+  ix.postIndex = binary.BigEndian.Uint32(d[n+16:])
+
+  // the sections follow each other in this order.
+  offs := []uint32{uint32(len(magic)), ix.pathData, ix.nameData, ix.postData, ix.nameIndex, ix.postIndex, n}
+  for i := 1; i < len(offs); i++ {
+    if offs[i] < offs[i-1] {
+      return nil, corruptError(mm.f)
+    }
+  }
+  if (ix.postIndex-ix.nameIndex)%4 != 0 || ix.postIndex-ix.nameIndex < 4 || (n-ix.postIndex)%postEntrySize != 0 {
+    return nil, corruptError(mm.f)
+  }
+
   ix.numName = int((ix.postIndex-ix.nameIndex)/4) - 1
   ix.numPost = int((n - ix.postIndex) / postEntrySize)
-  return ix
+  return ix, nil
 }
 
 // slice returns the slice of index data starting at the given byte offset.
@@ -142,7 +177,12 @@ func (ix *Index) uvarint(off uint32) uint32 {
 }
 
 // Paths returns the list of indexed paths.
-func (ix *Index) Paths() []string {
+func (ix *Index) Paths() (paths []string, err error) {
+  defer recoverCorrupt(&err)
+  return ix.paths(), nil
+}
+
+func (ix *Index) paths() []string {
   off := ix.pathData
   var x []string
   for {
@@ -156,8 +196,19 @@ func (ix *Index) Paths() []string {
   return x
 }
 
+// NumNames returns the number of files in the index. File ids run from
+// 0 to NumNames()-1.
+func (ix *Index) NumNames() int {
+  return ix.numName
+}
+
 // NameBytes returns the name corresponding to the given fileid.
-func (ix *Index) NameBytes(fileid uint32) []byte {
+func (ix *Index) NameBytes(fileid uint32) (name []byte, err error) {
+  defer recoverCorrupt(&err)
+  return ix.nameBytes(fileid), nil
+}
+
+func (ix *Index) nameBytes(fileid uint32) []byte {
   off := ix.uint32(ix.nameIndex + 4*fileid)
   return ix.str(ix.nameData + off)
 }
@@ -172,8 +223,13 @@ func (ix *Index) str(off uint32) []byte {
 }
 
 // Name returns the name corresponding to the given fileid.
-func (ix *Index) Name(fileid uint32) string {
-  return string(ix.NameBytes(fileid))
+func (ix *Index) Name(fileid uint32) (name string, err error) {
+  defer recoverCorrupt(&err)
+  return ix.name(fileid), nil
+}
+
+func (ix *Index) name(fileid uint32) string {
+  return string(ix.nameBytes(fileid))
 }
 
 // listAt returns the index list entry at the given offset.
@@ -273,8 +329,9 @@ func (r *postReader) next() bool {
   return false
 }
 
-func (ix *Index) PostingList(trigram uint32) []uint32 {
-  return ix.postingList(trigram, nil)
+func (ix *Index) PostingList(trigram uint32) (list []uint32, err error) {
+  defer recoverCorrupt(&err)
+  return ix.postingList(trigram, nil), nil
 }
 
 func (ix *Index) postingList(trigram uint32, restrict []uint32) []uint32 {
@@ -287,8 +344,9 @@ func (ix *Index) postingList(trigram uint32, restrict []uint32) []uint32 {
   return x
 }
 
-func (ix *Index) PostingAnd(list []uint32, trigram uint32) []uint32 {
-  return ix.postingAnd(list, trigram, nil)
+func (ix *Index) PostingAnd(list []uint32, trigram uint32) (and []uint32, err error) {
+  defer recoverCorrupt(&err)
+  return ix.postingAnd(list, trigram, nil), nil
 }
 
 func (ix *Index) postingAnd(list []uint32, trigram uint32, restrict []uint32) []uint32 {
@@ -309,8 +367,9 @@ func (ix *Index) postingAnd(list []uint32, trigram uint32, restrict []uint32) []
   return x
 }
 
-func (ix *Index) PostingOr(list []uint32, trigram uint32) []uint32 {
-  return ix.postingOr(list, trigram, nil)
+func (ix *Index) PostingOr(list []uint32, trigram uint32) (or []uint32, err error) {
+  defer recoverCorrupt(&err)
+  return ix.postingOr(list, trigram, nil), nil
 }
 
 func (ix *Index) postingOr(list []uint32, trigram uint32, restrict []uint32) []uint32 {
@@ -333,8 +392,11 @@ func (ix *Index) postingOr(list []uint32, trigram uint32, restrict []uint32) []u
   return x
 }
 
-func (ix *Index) PostingQuery(q *Query) []uint32 {
-  return ix.postingQuery(q, nil)
+// PostingQuery returns the ids of the files that may match q. It fails
+// when the posting lists it reads are damaged.
+func (ix *Index) PostingQuery(q *Query) (list []uint32, err error) {
+  defer recoverCorrupt(&err)
+  return ix.postingQuery(q, nil), nil
 }
 
 func (ix *Index) postingQuery(q *Query, restrict []uint32) (ret []uint32) {
@@ -410,8 +472,31 @@ func mergeOr(l1, l2 []uint32) []uint32 {
   return l
 }
 
+// A corruption is the panic raised by corrupt, which the exported methods
+// reading the index recover from and return as an error. A damaged index
+// thus fails the searches that read it rather than the whole process.
+type corruption struct {
+  err error
+}
+
 func corrupt(file *os.File) {
-  log.Fatalf("corrupt index: %s", file.Name())
+  panic(corruption{corruptError(file)})
+}
+
+// recoverCorrupt recovers from a panic raised by corrupt, storing its
+// error in err. Other panics are passed on.
+func recoverCorrupt(err *error) {
+  if r := recover(); r != nil {
+    c, ok := r.(corruption)
+    if !ok {
+      panic(r)
+    }
+    *err = c.err
+  }
+}
+
+func corruptError(file *os.File) error {
+  return fmt.Errorf("corrupt index: %s", file.Name())
 }
 
 // An mmapData is mmap'ed read-only data from a file.
@@ -426,12 +511,17 @@ func (m *mmapData) close() error {
 }
 
 // mmap maps the given file into memory.
-func mmap(file string) mmapData {
+func mmap(file string) (mmapData, error) {
   f, err := os.Open(file)
   if err != nil {
-    log.Fatal(err)
+    return mmapData{}, err
+  }
+  mm, err := mmapFile(f)
+  if err != nil {
+    f.Close()
+    return mmapData{}, err
   }
-  return mmapFile(f)
+  return mm, nil
 }
 
 // File returns the name of the index file to use.
@@ -26,23 +26,23 @@ func TestTrivialPosting(t *testing.T) {
 	defer os.Remove(f.Name())
 	out := f.Name()
 	buildIndex(out, nil, postFiles)
-	ix := Open(out)
-	if l := ix.PostingList(tri('S', 'e', 'a')); !equalList(l, []uint32{1, 3}) {
+	ix := mustOpen(t, out)
+	if l := ix.postingList(tri('S', 'e', 'a'), nil); !equalList(l, []uint32{1, 3}) {
 		t.Errorf("PostingList(Sea) = %v, want [1 3]", l)
 	}
-	if l := ix.PostingList(tri('G', 'o', 'o')); !equalList(l, []uint32{1, 2, 3}) {
+	if l := ix.postingList(tri('G', 'o', 'o'), nil); !equalList(l, []uint32{1, 2, 3}) {
 		t.Errorf("PostingList(Goo) = %v, want [1 2 3]", l)
 	}
-	if l := ix.PostingAnd(ix.PostingList(tri('S', 'e', 'a')), tri('G', 'o', 'o')); !equalList(l, []uint32{1, 3}) {
+	if l := ix.postingAnd(ix.postingList(tri('S', 'e', 'a'), nil), tri('G', 'o', 'o'), nil); !equalList(l, []uint32{1, 3}) {
 		t.Errorf("PostingList(Sea&Goo) = %v, want [1 3]", l)
 	}
-	if l := ix.PostingAnd(ix.PostingList(tri('G', 'o', 'o')), tri('S', 'e', 'a')); !equalList(l, []uint32{1, 3}) {
+	if l := ix.postingAnd(ix.postingList(tri('G', 'o', 'o'), nil), tri('S', 'e', 'a'), nil); !equalList(l, []uint32{1, 3}) {
 		t.Errorf("PostingList(Goo&Sea) = %v, want [1 3]", l)
 	}
-	if l := ix.PostingOr(ix.PostingList(tri('S', 'e', 'a')), tri('G', 'o', 'o')); !equalList(l, []uint32{1, 2, 3}) {
+	if l := ix.postingOr(ix.postingList(tri('S', 'e', 'a'), nil), tri('G', 'o', 'o'), nil); !equalList(l, []uint32{1, 2, 3}) {
 		t.Errorf("PostingList(Sea|Goo) = %v, want [1 2 3]", l)
 	}
-	if l := ix.PostingOr(ix.PostingList(tri('G', 'o', 'o')), tri('S', 'e', 'a')); !equalList(l, []uint32{1, 2, 3}) {
+	if l := ix.postingOr(ix.postingList(tri('G', 'o', 'o'), nil), tri('S', 'e', 'a'), nil); !equalList(l, []uint32{1, 2, 3}) {
 		t.Errorf("PostingList(Goo|Sea) = %v, want [1 2 3]", l)
 	}
 }
@@ -58,3 +58,85 @@ func equalList(x, y []uint32) bool {
 	}
 	return true
 }
+
+func mustOpen(t *testing.T, file string) *Index {
+	ix, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ix
+}
+
+func TestOpenCorrupt(t *testing.T) {
+	f, _ := ioutil.TempFile("", "index-test")
+	defer os.Remove(f.Name())
+	out := f.Name()
+	buildIndex(out, nil, postFiles)
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bad := map[string][]byte{
+		"empty":     nil,
+		"truncated": data[:len(data)/2],
+		"no magic":  append([]byte("not an index\n"), data[len(magic):]...),
+	}
+
+	// offsets pointing past the trailer.
+	off := append([]byte(nil), data...)
+	copy(off[len(off)-len(trailerMagic)-4:], []byte{0xff, 0xff, 0xff, 0xff})
+	bad["bad offsets"] = off
+
+	for name, b := range bad {
+		if err := ioutil.WriteFile(out, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open(out); err == nil {
+			t.Errorf("%s: Open succeeded", name)
+		}
+	}
+
+	if _, err := Open(out + ".missing"); err == nil {
+		t.Error("Open of a missing file succeeded")
+	}
+}
+
+func TestCorruptPostingList(t *testing.T) {
+	f, _ := ioutil.TempFile("", "index-test")
+	defer os.Remove(f.Name())
+	out := f.Name()
+	buildIndex(out, nil, postFiles)
+
+	ix := mustOpen(t, out)
+	trigram, _, offset := ix.listAt(0)
+	at := ix.postData + offset + 3
+	ix.Close()
+
+	// a zero delta for the first file of the first posting list.
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[at] = 0
+	if err := ioutil.WriteFile(out, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ix = mustOpen(t, out)
+	defer ix.Close()
+
+	if _, err := ix.PostingList(trigram); err == nil {
+		t.Error("a corrupt posting list was read")
+	}
+
+	q := &Query{Op: QAnd, Trigram: []string{string([]byte{byte(trigram >> 16), byte(trigram >> 8), byte(trigram)})}}
+	if _, err := ix.PostingQuery(q); err == nil {
+		t.Error("a query read a corrupt posting list")
+	}
+
+	if name, err := ix.Name(0); err != nil || name != "file0" {
+		t.Errorf("Name(0) = %q, %v, want file0", name, err)
+	}
+}
@@ -55,6 +55,8 @@ type IndexWriter struct {
 
 	inbuf []byte     // input buffer
 	main  *bufWriter // main index file
+
+	err error // first error flushing post entries, returned by Flush
 }
 
 const npost = 64 << 20 / 8 // 64 MB worth of post entries
@@ -219,8 +221,9 @@ func (ix *IndexWriter) Add(name string, f io.Reader) string {
 	return ""
 }
 
-// Flush flushes the index entry to the target file.
-func (ix *IndexWriter) Flush() {
+// Flush flushes the index entry to the target file. It returns the first
+// error writing the index or any of its temporary files.
+func (ix *IndexWriter) Flush() error {
 	ix.addName("")
 
 	var off [5]uint32
@@ -234,7 +237,9 @@ func (ix *IndexWriter) Flush() {
 	off[1] = ix.main.offset()
 	copyFile(ix.main, ix.nameData)
 	off[2] = ix.main.offset()
-	ix.mergePost(ix.main)
+	if err := ix.mergePost(ix.main); err != nil && ix.err == nil {
+		ix.err = err
+	}
 	off[3] = ix.main.offset()
 	copyFile(ix.main, ix.nameIndex)
 	off[4] = ix.main.offset()
@@ -244,7 +249,7 @@ func (ix *IndexWriter) Flush() {
 	}
 	ix.main.writeString(trailerMagic)
 
-	os.Remove(ix.nameData.name)
+	ix.nameData.remove()
 	for _, d := range ix.postData {
 		unmmap(d)
 	}
@@ -252,23 +257,40 @@ func (ix *IndexWriter) Flush() {
 		f.Close()
 		os.Remove(f.Name())
 	}
-	os.Remove(ix.nameIndex.name)
-	os.Remove(ix.postIndex.name)
+	ix.nameIndex.remove()
+	ix.postIndex.remove()
 
 	log.Printf("%d data bytes, %d index bytes", ix.totalBytes, ix.main.offset())
 
 	ix.main.flush()
+
+	if ix.err != nil {
+		return ix.err
+	}
+	for _, b := range []*bufWriter{ix.nameData, ix.nameIndex, ix.postIndex, ix.main} {
+		if b.err != nil {
+			return b.err
+		}
+	}
+	return nil
 }
 
 func (ix *IndexWriter) Close() {
-	ix.main.file.Close()
+	ix.main.close()
 }
 
 func copyFile(dst, src *bufWriter) {
 	dst.flush()
-	_, err := io.Copy(dst.file, src.finish())
-	if err != nil {
-		log.Fatalf("copying %s to %s: %v", src.name, dst.name, err)
+	f := src.finish()
+	if dst.err != nil {
+		return
+	}
+	if src.err != nil {
+		dst.err = src.err
+		return
+	}
+	if _, err := io.Copy(dst.file, f); err != nil {
+		dst.err = fmt.Errorf("copying %s to %s: %v", src.name, dst.name, err)
 	}
 }
 
@@ -288,11 +310,18 @@ func (ix *IndexWriter) addName(name string) uint32 {
 }
 
 // flushPost writes ix.post to a new temporary file and
-// clears the slice.
+// clears the slice. The first error doing so is returned by Flush.
 func (ix *IndexWriter) flushPost() {
+	if ix.err != nil {
+		ix.post = ix.post[:0]
+		return
+	}
+
 	w, err := ioutil.TempFile("", "csearch-index")
 	if err != nil {
-		log.Fatal(err)
+		ix.err = err
+		ix.post = ix.post[:0]
+		return
 	}
 	if ix.Verbose {
 		log.Printf("flush %d entries to %s", len(ix.post), w.Name())
@@ -302,28 +331,33 @@ func (ix *IndexWriter) flushPost() {
 	// Write the raw ix.post array to disk as is.
 	// This process is the one reading it back in, so byte order is not a concern.
 	data := (*[npost * 8]byte)(unsafe.Pointer(&ix.post[0]))[:len(ix.post)*8]
+	ix.post = ix.post[:0]
 	if n, err := w.Write(data); err != nil || n < len(data) {
-		if err != nil {
-			log.Fatal(err)
+		if err == nil {
+			err = fmt.Errorf("short write writing %s", w.Name())
 		}
-		log.Fatalf("short write writing %s", w.Name())
+		ix.err = err
+		w.Close()
+		os.Remove(w.Name())
+		return
 	}
 
-	ix.post = ix.post[:0]
 	w.Seek(0, 0)
 	ix.postFile = append(ix.postFile, w)
 }
 
 // mergePost reads the flushed index entries and merges them
 // into posting lists, writing the resulting lists to out.
-func (ix *IndexWriter) mergePost(out *bufWriter) {
+func (ix *IndexWriter) mergePost(out *bufWriter) error {
 	var h postHeap
 
 	log.Printf("merge %d files + mem", len(ix.postFile))
 	for _, f := range ix.postFile {
-		ix.postData = append(
-			ix.postData,
-			h.addFile(f))
+		data, err := h.addFile(f)
+		if err != nil {
+			return err
+		}
+		ix.postData = append(ix.postData, data)
 	}
 	sortPost(ix.post)
 	h.addMem(ix.post)
@@ -359,6 +393,7 @@ func (ix *IndexWriter) mergePost(out *bufWriter) {
 			break
 		}
 	}
+	return nil
 }
 
 // A postChunk represents a chunk of post entries flushed to disk or
@@ -375,11 +410,15 @@ type postHeap struct {
 	ch []*postChunk
 }
 
-func (h *postHeap) addFile(f *os.File) []byte {
-	data := mmapFile(f).d
+func (h *postHeap) addFile(f *os.File) ([]byte, error) {
+	mm, err := mmapFile(f)
+	if err != nil {
+		return nil, err
+	}
+	data := mm.d
 	m := (*[npost]postEntry)(unsafe.Pointer(&data[0]))[:len(data)/8]
 	h.addMem(m)
-	return data
+	return data, nil
 }
 
 func (h *postHeap) addMem(x []postEntry) {
@@ -488,16 +527,20 @@ func (h *postHeap) siftUp(j int) {
 }
 
 // A bufWriter is a convenience wrapper: a closeable bufio.Writer.
+// Once writing fails, the writer drops everything else written to
+// it and err holds the first error.
 type bufWriter struct {
 	name string
 	file *os.File
 	buf  []byte
 	tmp  [8]byte
+	err  error
 }
 
 // bufCreate creates a new file with the given name and returns a
 // corresponding bufWriter.  If name is empty, bufCreate uses a
-// temporary file.
+// temporary file.  A file that cannot be created is reported in
+// the err of the writer.
 func bufCreate(name string) *bufWriter {
 	var (
 		f   *os.File
@@ -509,7 +552,11 @@ func bufCreate(name string) *bufWriter {
 		f, err = ioutil.TempFile("", "csearch")
 	}
 	if err != nil {
-		log.Fatal(err)
+		return &bufWriter{
+			name: name,
+			buf:  make([]byte, 0, 256<<10),
+			err:  err,
+		}
 	}
 	return &bufWriter{
 		name: f.Name(),
@@ -523,8 +570,11 @@ func (b *bufWriter) write(x []byte) {
 	if len(x) > n {
 		b.flush()
 		if len(x) >= cap(b.buf) {
+			if b.err != nil {
+				return
+			}
 			if _, err := b.file.Write(x); err != nil {
-				log.Fatalf("writing %s: %v", b.name, err)
+				b.err = fmt.Errorf("writing %s: %v", b.name, err)
 			}
 			return
 		}
@@ -544,8 +594,11 @@ func (b *bufWriter) writeString(s string) {
 	if len(s) > n {
 		b.flush()
 		if len(s) >= cap(b.buf) {
+			if b.err != nil {
+				return
+			}
 			if _, err := b.file.WriteString(s); err != nil {
-				log.Fatalf("writing %s: %v", b.name, err)
+				b.err = fmt.Errorf("writing %s: %v", b.name, err)
 			}
 			return
 		}
@@ -554,11 +607,16 @@ func (b *bufWriter) writeString(s string) {
 }
 
 // offset returns the current write offset.
+// It is zero once writing has failed.
 func (b *bufWriter) offset() uint32 {
+	if b.err != nil {
+		return 0
+	}
 	off, _ := b.file.Seek(0, 1)
 	off += int64(len(b.buf))
 	if int64(uint32(off)) != off {
-		log.Fatalf("index is larger than 4GB")
+		b.err = fmt.Errorf("%s: index is larger than 4GB", b.name)
+		return 0
 	}
 	return uint32(off)
 }
@@ -567,21 +625,41 @@ func (b *bufWriter) flush() {
 	if len(b.buf) == 0 {
 		return
 	}
-	_, err := b.file.Write(b.buf)
-	if err != nil {
-		log.Fatalf("writing %s: %v", b.name, err)
+	if b.err == nil {
+		if _, err := b.file.Write(b.buf); err != nil {
+			b.err = fmt.Errorf("writing %s: %v", b.name, err)
+		}
 	}
 	b.buf = b.buf[:0]
 }
 
 // finish flushes the file to disk and returns an open file ready for reading.
+// It returns nil once writing has failed.
 func (b *bufWriter) finish() *os.File {
 	b.flush()
+	if b.err != nil {
+		return nil
+	}
 	f := b.file
 	f.Seek(0, 0)
 	return f
 }
 
+// close closes the file, if it was ever created.
+func (b *bufWriter) close() {
+	if b.file != nil {
+		b.file.Close()
+	}
+}
+
+// remove closes and removes a temporary file.
+func (b *bufWriter) remove() {
+	if b.file != nil {
+		b.file.Close()
+		os.Remove(b.name)
+	}
+}
+
 func (b *bufWriter) writeTrigram(t uint32) {
 	if cap(b.buf)-len(b.buf) < 3 {
 		b.flush()
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"testing"
@@ -164,6 +165,21 @@ func TestTrivialWriteDisk(t *testing.T) {
 	testTrivialWrite(t, true)
 }
 
+func TestFlushError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ix := Create(filepath.Join(dir, "no", "index"))
+	defer ix.Close()
+	ix.Add("f", strings.NewReader("hello world"))
+	if err := ix.Flush(); err == nil {
+		t.Fatal("flushed an index into a directory that does not exist")
+	}
+}
+
 func TestHeap(t *testing.T) {
 	h := &postHeap{}
 	es := []postEntry{7, 4, 3, 2, 4}
@@ -0,0 +1,138 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"regexp/syntax"
+
+	"github.com/it-projects-llc/hound/codesearch/sparse"
+)
+
+// A finder locates matches by simulating the same byte program the DFA
+// runs, keeping track of where each thread started. It follows the DFA
+// on case folding and word boundaries, so every match it reports is one
+// the DFA agrees with, and it picks the leftmost-longest match.
+type finder struct {
+	prog   *syntax.Prog
+	q1, q2 threadq
+}
+
+// A threadq is a queue of program instructions along with where the
+// thread at each of them started.
+type threadq struct {
+	sparse.Set
+	start []int
+}
+
+func (f *finder) init(prog *syntax.Prog) {
+	f.prog = prog
+	for _, q := range []*threadq{&f.q1, &f.q2} {
+		q.Init(uint32(len(prog.Inst)))
+		q.start = make([]int, len(prog.Inst))
+	}
+}
+
+// emptyFlags returns the empty-width conditions that hold before b[i].
+func emptyFlags(b []byte, i int) syntax.EmptyOp {
+	var flag syntax.EmptyOp
+	if i == 0 {
+		flag |= syntax.EmptyBeginText | syntax.EmptyBeginLine
+	} else if b[i-1] == '\n' {
+		flag |= syntax.EmptyBeginLine
+	}
+	if i == len(b) {
+		flag |= syntax.EmptyEndText | syntax.EmptyEndLine
+	} else if b[i] == '\n' {
+		flag |= syntax.EmptyEndLine
+	}
+
+	before, after := endText, endText
+	if i > 0 {
+		before = int(b[i-1])
+	}
+	if i < len(b) {
+		after = int(b[i])
+	}
+	if isWordByte(before) != isWordByte(after) {
+		flag |= syntax.EmptyWordBoundary
+	} else {
+		flag |= syntax.EmptyNoWordBoundary
+	}
+	return flag
+}
+
+// add adds the thread at id, started at start, to the queue, expanding
+// according to flag. Threads already on the queue started earlier and
+// take precedence.
+func (f *finder) add(q *threadq, id uint32, start int, flag syntax.EmptyOp) {
+	if q.Has(id) {
+		return
+	}
+	q.Add(id)
+	q.start[id] = start
+	i := &f.prog.Inst[id]
+	switch i.Op {
+	case syntax.InstCapture, syntax.InstNop:
+		f.add(q, i.Out, start, flag)
+	case syntax.InstAlt, syntax.InstAltMatch:
+		f.add(q, i.Out, start, flag)
+		f.add(q, i.Arg, start, flag)
+	case syntax.InstEmptyWidth:
+		if syntax.EmptyOp(i.Arg)&^flag == 0 {
+			f.add(q, i.Out, start, flag)
+		}
+	}
+}
+
+// find returns the leftmost-longest match in b that starts at or after
+// pos, or -1, -1 if there is none.
+func (f *finder) find(b []byte, pos int) (start, end int) {
+	start, end = -1, -1
+	runq, next := &f.q1, &f.q2
+	runq.Reset()
+
+	for i := pos; ; i++ {
+		// no match can start after one that has been found.
+		if start < 0 {
+			f.add(runq, uint32(f.prog.Start), i, emptyFlags(b, i))
+		}
+
+		// the queue is ordered by start, so the first match is the
+		// leftmost one ending here.
+		for _, id := range runq.Dense() {
+			if f.prog.Inst[id].Op != syntax.InstMatch {
+				continue
+			}
+			if s := runq.start[id]; start < 0 || s < start || s == start && i > end {
+				start, end = s, i
+			}
+			break
+		}
+
+		if i == len(b) || start >= 0 && runq.Len() == 0 {
+			return
+		}
+
+		c := int(b[i])
+		flag := emptyFlags(b, i+1)
+		next.Reset()
+		for _, id := range runq.Dense() {
+			inst := &f.prog.Inst[id]
+			if inst.Op != instByteRange || start >= 0 && runq.start[id] > start {
+				continue
+			}
+			lo := int((inst.Arg >> 8) & 0xFF)
+			hi := int(inst.Arg & 0xFF)
+			ch := c
+			if inst.Arg&argFold != 0 && 'a' <= ch && ch <= 'z' {
+				ch += 'A' - 'a'
+			}
+			if lo <= ch && ch <= hi {
+				f.add(next, inst.Out, runq.start[id], flag)
+			}
+		}
+		runq, next = next, runq
+	}
+}
@@ -6,7 +6,9 @@
 // use in grep-like programs.
 package regexp
 
-import "regexp/syntax"
+import (
+	"regexp/syntax"
+)
 
 func bug() {
 	panic("codesearch/regexp: internal error")
@@ -18,6 +20,7 @@ type Regexp struct {
 	Syntax *syntax.Regexp
 	expr   string // original expression
 	m      matcher
+	f      finder
 }
 
 // String returns the source text used to compile the regular expression.
@@ -47,6 +50,7 @@ func Compile(expr string) (*Regexp, error) {
 	if err := r.m.init(prog); err != nil {
 		return nil, err
 	}
+	r.f.init(prog)
 	return r, nil
 }
 
@@ -57,3 +61,31 @@ func (r *Regexp) Match(b []byte, beginText, endText bool) (end int) {
 func (r *Regexp) MatchString(s string, beginText, endText bool) (end int) {
 	return r.m.matchString(s, beginText, endText)
 }
+
+// FindAllIndex returns the start and end offsets of at most n successive
+// non-overlapping matches of the expression in b; n < 0 means all matches.
+// The DFA used by Match only reports where a matching line ends, so the
+// offsets come from running the same program while tracking where each
+// match starts. Matches are leftmost-longest, and an empty match right
+// after a previous match is not reported.
+func (r *Regexp) FindAllIndex(b []byte, n int) [][]int {
+	var idxs [][]int
+	prev := -1
+	for pos := 0; pos <= len(b) && (n < 0 || len(idxs) < n); {
+		start, end := r.f.find(b, pos)
+		if start < 0 {
+			break
+		}
+		if start == end && start == prev {
+			pos = start + 1
+			continue
+		}
+		idxs = append(idxs, []int{start, end})
+		prev = end
+		pos = end
+		if start == end {
+			pos++
+		}
+	}
+	return idxs
+}
@@ -217,3 +217,47 @@ func TestGrep(t *testing.T) {
 		}
 	}
 }
+
+var findAllIndexTests = []struct {
+	re string
+	s  string
+	m  [][]int
+}{
+	{`a+`, "baaab", [][]int{{1, 4}}},
+	{`b`, "abcb", [][]int{{1, 2}, {3, 4}}},
+	{`(?i)ab`, "xAbyaB", [][]int{{1, 3}, {4, 6}}},
+	{`\bid\b`, "id userid id_x (id)", [][]int{{0, 2}, {16, 18}}},
+	{`x`, "abc", nil},
+	{`a|ab`, "xab", [][]int{{1, 3}}},
+	{`x*`, "axb", [][]int{{0, 0}, {1, 2}, {3, 3}}},
+	{`(?m)^b.$`, "ab\nbc\nb", [][]int{{3, 5}}},
+	{`(?s)a.b`, "a\nb", [][]int{{0, 3}}},
+	{`a.b`, "a\nb", nil},
+	{`\bx\b`, "éxé xx", [][]int{{2, 3}}},
+	{`(?i)K`, "kK\u212a", [][]int{{0, 1}, {1, 2}, {2, 5}}},
+	{`\pL+`, "héllo wörld", [][]int{{0, 6}, {7, 13}}},
+}
+
+func TestFindAllIndex(t *testing.T) {
+	for _, tt := range findAllIndexTests {
+		re, err := Compile(tt.re)
+		if err != nil {
+			t.Errorf("Compile(%#q): %v", tt.re, err)
+			continue
+		}
+		m := re.FindAllIndex([]byte(tt.s), -1)
+		if !reflect.DeepEqual(m, tt.m) {
+			t.Errorf("FindAllIndex(%#q, %q) = %v, want %v", tt.re, tt.s, m, tt.m)
+		}
+
+		// a line has a match exactly when the DFA matches it.
+		found := len(m) > 0
+		if matched := re.MatchString(tt.s, true, true) >= 0; matched != found && !strings.Contains(tt.s, "\n") {
+			t.Errorf("MatchString(%#q, %q) = %t, but found %v", tt.re, tt.s, matched, m)
+		}
+
+		if m := re.FindAllIndex([]byte(tt.s), 1); len(tt.m) > 0 && !reflect.DeepEqual(m, tt.m[:1]) {
+			t.Errorf("FindAllIndex(%#q, %q, 1) = %v, want %v", tt.re, tt.s, m, tt.m[:1])
+		}
+	}
+}
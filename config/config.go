@@ -16,6 +16,8 @@ const (
 	defaultBaseUrl               = "{url}/blob/{rev}/{path}{anchor}"
 	defaultAnchor                = "#L{line}"
 	defaultHealthChekURI         = "/healthz"
+	defaultSearchMaxMatches      = 5000
+	defaultSearchWorkers         = 4
 )
 
 type UrlPattern struct {
@@ -32,6 +34,9 @@ type Repo struct {
 	ExcludeDotFiles   bool           `json:"exclude-dot-files"`
 	EnablePollUpdates *bool          `json:"enable-poll-updates"`
 	EnablePushUpdates *bool          `json:"enable-push-updates"`
+	IndexSymbols      bool           `json:"index-symbols"`
+	FileCacheMB       int            `json:"file-cache-mb"`
+	ResultCacheMB     int            `json:"result-cache-mb"`
 }
 
 // Used for interpreting the config value for fields that use *bool. If a value
@@ -53,11 +58,34 @@ func (r *Repo) PushUpdatesEnabled() bool {
 	return optionToBool(r.EnablePushUpdates, defaultPushEnabled)
 }
 
+// The number of bytes of file contents the index of this repo keeps in
+// memory. The cache is off unless file-cache-mb is set, since every repo
+// has a cache of its own.
+func (r *Repo) FileCacheBytes() int64 {
+	if r.FileCacheMB < 0 {
+		return 0
+	}
+	return int64(r.FileCacheMB) << 20
+}
+
+// The number of bytes of search responses the searcher of this repo keeps
+// in memory. Like the file cache, the result cache is off unless
+// result-cache-mb is set.
+func (r *Repo) ResultCacheBytes() int64 {
+	if r.ResultCacheMB < 0 {
+		return 0
+	}
+	return int64(r.ResultCacheMB) << 20
+}
+
 type Config struct {
 	DbPath                string           `json:"dbpath"`
 	Repos                 map[string]*Repo `json:"repos"`
 	MaxConcurrentIndexers int              `json:"max-concurrent-indexers"`
 	HealthCheckURI        string           `json:"health-check-uri"`
+	SearchMaxMatches      int              `json:"search-max-matches"`
+	MsSearchTimeout       int              `json:"search-timeout-ms"`
+	SearchWorkers         int              `json:"search-workers"`
 }
 
 // SecretMessage is just like json.RawMessage but it will not
@@ -123,6 +151,15 @@ func initConfig(c *Config) {
 	if c.HealthCheckURI == "" {
 		c.HealthCheckURI = defaultHealthChekURI
 	}
+
+	if c.SearchMaxMatches == 0 {
+		c.SearchMaxMatches = defaultSearchMaxMatches
+	}
+
+	// every repo searched at once has workers of its own, a few each.
+	if c.SearchWorkers == 0 {
+		c.SearchWorkers = defaultSearchWorkers
+	}
 }
 
 func (c *Config) LoadFromFile(filename string) error {
@@ -35,3 +35,25 @@ func TestExampleConfigsAreValid(t *testing.T) {
 		}
 	}
 }
+
+func TestCacheBytes(t *testing.T) {
+	tests := []struct {
+		mb   int
+		want int64
+	}{
+		{0, 0},
+		{64, 64 << 20},
+		{-1, 0},
+	}
+
+	for _, test := range tests {
+		r := &Repo{FileCacheMB: test.mb, ResultCacheMB: test.mb}
+		initRepo(r)
+		if got := r.FileCacheBytes(); got != test.want {
+			t.Errorf("FileCacheBytes with file-cache-mb %d = %d, want %d", test.mb, got, test.want)
+		}
+		if got := r.ResultCacheBytes(); got != test.want {
+			t.Errorf("ResultCacheBytes with result-cache-mb %d = %d, want %d", test.mb, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package index
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// The bytes a cached file is charged for on top of its contents and lines,
+// roughly what its entry in the cache takes.
+const cachedFileOverhead = 128
+
+// The decompressed contents of an indexed file along with where each of its
+// lines starts.
+type cachedFile struct {
+	name  string
+	data  []byte
+	lines []int
+}
+
+func newCachedFile(name string, data []byte) *cachedFile {
+	f := &cachedFile{name: name, data: data}
+	for off := 0; off < len(data); {
+		f.lines = append(f.lines, off)
+		i := bytes.IndexByte(data[off:], '\n')
+		if i < 0 {
+			break
+		}
+		off += i + 1
+	}
+	return f
+}
+
+// Read the contents of a file from the raw store.
+func readCachedFile(raw RawStore, name string) (*cachedFile, error) {
+	c, err := raw.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		return nil, err
+	}
+	return newCachedFile(name, data), nil
+}
+
+// The number of lines in the file, a trailing newline does not start
+// another line.
+func (f *cachedFile) numLines() int {
+	return len(f.lines)
+}
+
+// Line i of the file, 0-based, without its newline.
+func (f *cachedFile) line(i int) []byte {
+	end := len(f.data)
+	if i+1 < len(f.lines) {
+		end = f.lines[i+1]
+	}
+	return bytes.TrimSuffix(f.data[f.lines[i]:end], nl)
+}
+
+func (f *cachedFile) size() int64 {
+	return int64(len(f.data)) + int64(len(f.lines))*8 + int64(len(f.name)) + cachedFileOverhead
+}
+
+// Keeps the contents of the most recently read files of an index in memory,
+// up to a total number of bytes. It is safe for concurrent use.
+type fileCache struct {
+	lck     sync.Mutex
+	max     int64
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+func newFileCache(max int64) *fileCache {
+	return &fileCache{
+		max:     max,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// The contents of a file, read from raw unless they are cached. Reports
+// whether they were.
+func (c *fileCache) get(raw RawStore, name string) (*cachedFile, bool, error) {
+	c.lck.Lock()
+	if e, ok := c.entries[name]; ok {
+		c.lru.MoveToFront(e)
+		c.lck.Unlock()
+		return e.Value.(*cachedFile), true, nil
+	}
+	c.lck.Unlock()
+
+	// files are read without holding the lock, so a file missed by two
+	// searches at once is read twice but only cached once.
+	f, err := readCachedFile(raw, name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	c.add(f)
+	return f, false, nil
+}
+
+func (c *fileCache) add(f *cachedFile) {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+
+	// files that would not fit even in an empty cache are never cached.
+	if f.size() > c.max || c.entries[f.name] != nil {
+		return
+	}
+
+	c.entries[f.name] = c.lru.PushFront(f)
+	c.size += f.size()
+
+	for c.size > c.max {
+		e := c.lru.Back()
+		old := c.lru.Remove(e).(*cachedFile)
+		delete(c.entries, old.name)
+		c.size -= old.size()
+	}
+}
+
+// Drop every cached file.
+func (c *fileCache) clear() {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+
+	c.lru.Init()
+	c.entries = map[string]*list.Element{}
+	c.size = 0
+}
+
+// The raw store of an index seen through its file cache. Each grep worker
+// has its own, counting the files it found cached.
+type cachedStore struct {
+	raw   RawStore
+	cache *fileCache
+	hits  int
+}
+
+func (s *cachedStore) Open(name string) (io.ReadCloser, error) {
+	f, err := s.file(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+// The cached contents of a file, which are grepped in place rather than
+// copied.
+func (s *cachedStore) file(name string) (*cachedFile, error) {
+	f, hit, err := s.cache.get(s.raw, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if hit {
+		s.hits++
+	}
+	return f, nil
+}
+
+// The store belongs to the index, it is closed along with it.
+func (s *cachedStore) Close() error {
+	return nil
+}
@@ -0,0 +1,164 @@
+package index
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A raw store of files held in memory, counting how often each is opened.
+type memStore struct {
+	files map[string]string
+	opens map[string]int
+}
+
+func (s *memStore) Open(name string) (io.ReadCloser, error) {
+	c, ok := s.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	s.opens[name]++
+	return ioutil.NopCloser(strings.NewReader(c)), nil
+}
+
+func (s *memStore) Close() error {
+	return nil
+}
+
+func TestCachedFileLines(t *testing.T) {
+	tests := []struct {
+		data  string
+		lines []string
+	}{
+		{"", nil},
+		{"\n", []string{""}},
+		{"a", []string{"a"}},
+		{"a\n", []string{"a"}},
+		{"a\n\nb", []string{"a", "", "b"}},
+		{"a\nb\n\n", []string{"a", "b", ""}},
+	}
+
+	for _, test := range tests {
+		f := newCachedFile("f", []byte(test.data))
+
+		var lines []string
+		for i := 0; i < f.numLines(); i++ {
+			lines = append(lines, string(f.line(i)))
+		}
+
+		if !reflect.DeepEqual(lines, test.lines) {
+			t.Errorf("lines of %q = %q, want %q", test.data, lines, test.lines)
+		}
+	}
+}
+
+func TestFileCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	s := &memStore{
+		files: map[string]string{
+			"a":   strings.Repeat("a", 100),
+			"b":   strings.Repeat("b", 100),
+			"c":   strings.Repeat("c", 100),
+			"big": strings.Repeat("x", 1000),
+		},
+		opens: map[string]int{},
+	}
+
+	// room for two of the small files.
+	one := newCachedFile("a", []byte(s.files["a"])).size()
+	c := newFileCache(2*one + one/2)
+
+	get := func(name string, hit bool) {
+		f, ok, err := c.get(s, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != hit {
+			t.Errorf("get %s: cached = %t, want %t", name, ok, hit)
+		}
+		if string(f.data) != s.files[name] {
+			t.Errorf("get %s: wrong contents", name)
+		}
+	}
+
+	get("a", false)
+	get("b", false)
+	get("a", true)
+
+	// b is the least recently used.
+	get("c", false)
+	get("a", true)
+	get("c", true)
+	get("b", false)
+
+	// too large to be cached at all.
+	get("big", false)
+	get("big", false)
+	get("b", true)
+
+	if s.opens["a"] != 1 || s.opens["big"] != 2 {
+		t.Errorf("opens = %v", s.opens)
+	}
+
+	c.clear()
+	get("a", false)
+}
+
+func TestSearchWithFileCache(t *testing.T) {
+	ref, err := buildIndex(url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ref.Remove()
+
+	idx, err := ref.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	want, err := idx.Search(context.Background(), "func", &SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx.SetFileCacheSize(16 << 20)
+
+	for i, cached := range []bool{false, true} {
+		res, err := idx.Search(context.Background(), "func", &SearchOptions{Workers: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cached && res.FilesCached != res.FilesOpened {
+			t.Errorf("search %d: %d of %d files cached", i, res.FilesCached, res.FilesOpened)
+		} else if !cached && res.FilesCached != 0 {
+			t.Errorf("search %d: %d files cached before any were read", i, res.FilesCached)
+		}
+
+		res.Duration, res.FilesCached = 0, 0
+		want.Duration = 0
+		if !reflect.DeepEqual(res, want) {
+			t.Errorf("search %d: results differ with the file cache", i)
+		}
+	}
+
+	a, err := idx.ReadFile("cache_test.go", 2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx.SetFileCacheSize(0)
+
+	b, err := idx.ReadFile("cache_test.go", 2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("ReadFile differs with the file cache: %+v != %+v", a, b)
+	}
+}
@@ -0,0 +1,87 @@
+package index
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Returned by ReadFile when the path does not name a file in the index.
+var ErrFileNotFound = errors.New("No such file in the index")
+
+type FileResponse struct {
+	Filename string
+	Revision string
+
+	// The line numbers of the first and last of Lines along with the
+	// number of lines in the whole file.
+	From       int
+	To         int
+	TotalLines int
+
+	Lines []string
+}
+
+// Only relative paths that stay within the raw directory can name files in
+// the index.
+func isIndexPath(path string) bool {
+	if path == "" || filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return false
+	}
+	return path != ".." && !strings.HasPrefix(path, "../")
+}
+
+// Read lines from through to, both 1-based and inclusive, of an indexed file
+// as it was at the indexed revision. A zero to reads through the end of the
+// file. The range is clamped to the lines of the file.
+func (n *Index) ReadFile(path string, from, to int) (*FileResponse, error) {
+	n.lck.RLock()
+	defer n.lck.RUnlock()
+
+	if !isIndexPath(path) {
+		return nil, ErrFileNotFound
+	}
+
+	f, err := n.readFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrFileNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	total := f.numLines()
+	if from < 1 {
+		from = 1
+	}
+	if to <= 0 || to > total {
+		to = total
+	}
+	if from > to {
+		from = to + 1
+	}
+
+	lines := make([]string, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		lines = append(lines, string(f.line(i-1)))
+	}
+
+	return &FileResponse{
+		Filename:   path,
+		Revision:   n.Ref.Rev,
+		From:       from,
+		To:         to,
+		TotalLines: total,
+		Lines:      lines,
+	}, nil
+}
+
+// The contents of an indexed file, through the file cache when there is one.
+func (n *Index) readFile(name string) (*cachedFile, error) {
+	if n.cache == nil {
+		return readCachedFile(n.raw, name)
+	}
+
+	f, _, err := n.cache.get(n.raw, name)
+	return f, err
+}
@@ -0,0 +1,48 @@
+package index
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	ref, err := buildIndex(url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ref.Remove()
+
+	idx, err := ref.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	res, err := idx.ReadFile("file_test.go", 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(res.Lines, []string{"package index", "", "import ("}) {
+		t.Fatalf("unexpected lines: %q", res.Lines)
+	}
+
+	if res.Revision != rev || res.From != 1 || res.To != 3 || res.TotalLines < 3 {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+
+	res, err = idx.ReadFile("file_test.go", res.TotalLines, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(res.Lines, []string{"}"}) {
+		t.Fatalf("unexpected last line: %q", res.Lines)
+	}
+
+	for _, path := range []string{"nope.go", "../index/file.go", "/etc/passwd", "./file.go", "."} {
+		if _, err := idx.ReadFile(path, 0, 0); err != ErrFileNotFound {
+			t.Errorf("ReadFile(%q): expected ErrFileNotFound, got %v", path, err)
+		}
+	}
+}
@@ -2,9 +2,9 @@ package index
 
 import (
 	"bytes"
-	"compress/gzip"
+	"context"
 	"io"
-	"os"
+	"sort"
 
 	"github.com/it-projects-llc/hound/codesearch/regexp"
 )
@@ -15,6 +15,68 @@ type grepper struct {
 	buf []byte
 }
 
+// A matcher finds the first line in a buffer that contains a match. Match
+// returns the offset of the end of that line, or -1 if nothing matched.
+// FindAllIndex locates the individual matches within a line.
+// *regexp.Regexp is the general purpose implementation.
+type matcher interface {
+	Match(b []byte, beginText, endText bool) int
+	FindAllIndex(b []byte, n int) [][]int
+}
+
+// A matcher for a fixed string, which avoids running the DFA over the
+// whole buffer when a plain substring search will do.
+type literalMatcher struct {
+	lit []byte
+}
+
+func (m *literalMatcher) Match(b []byte, beginText, endText bool) int {
+	i := bytes.Index(b, m.lit)
+	if i < 0 {
+		return -1
+	}
+
+	i += len(m.lit)
+	if e := bytes.IndexByte(b[i:], '\n'); e >= 0 {
+		return i + e
+	}
+	return len(b)
+}
+
+func (m *literalMatcher) FindAllIndex(b []byte, n int) [][]int {
+	var r [][]int
+	for off := 0; n < 0 || len(r) < n; {
+		i := bytes.Index(b[off:], m.lit)
+		if i < 0 {
+			break
+		}
+		off += i
+		r = append(r, []int{off, off + len(m.lit)})
+		off += len(m.lit)
+	}
+	return r
+}
+
+// Finds the numbers of the lines starting at offsets into a buffer, which
+// must only ever move forward. The lines of a cached file are looked up
+// where they are known to start, others have their newlines counted.
+type lineCounter struct {
+	buf    []byte
+	starts []int // where each line starts, nil unless known
+	off    int   // where counting stopped
+	n      int   // the number of lines before off
+}
+
+// The 0-based number of the line starting at off.
+func (c *lineCounter) lineAt(off int) int {
+	if c.starts != nil {
+		return sort.SearchInts(c.starts, off)
+	}
+	c.n += countLines(c.buf[c.off:off])
+	c.off = off
+	return c.n
+}
+
 func countLines(b []byte) int {
 	n := 0
 	for {
@@ -28,15 +90,9 @@ func countLines(b []byte) int {
 	return n
 }
 
-func (g *grepper) grepFile(filename string, re *regexp.Regexp,
+func (g *grepper) grepFile(raw RawStore, name string, re *regexp.Regexp,
 	fn func(line []byte, lineno int) (bool, error)) error {
-	r, err := os.Open(filename)
-	if err != nil {
-		return err
-	}
-	defer r.Close()
-
-	c, err := gzip.NewReader(r)
+	c, err := raw.Open(name)
 	if err != nil {
 		return err
 	}
@@ -45,21 +101,47 @@ func (g *grepper) grepFile(filename string, re *regexp.Regexp,
 	return g.grep(c, re, fn)
 }
 
-func (g *grepper) grep2File(filename string, re *regexp.Regexp, nctx int,
-	fn func(line []byte, lineno int, before [][]byte, after [][]byte) (bool, error)) error {
-	r, err := os.Open(filename)
+// Read the whole file into the buffer of the grepper, where it stays until
+// the next file is read.
+func (g *grepper) readFile(raw RawStore, name string) ([]byte, error) {
+	c, err := raw.Open(name)
 	if err != nil {
-		return err
-	}
-	defer r.Close()
-
-	c, err := gzip.NewReader(r)
-	if err != nil {
-		return err
+		return nil, err
 	}
 	defer c.Close()
 
-	return g.grep2(c, re, nctx, fn)
+	return g.fillFrom(c)
+}
+
+// The indexes of the clauses that the contents of a file satisfy. A file
+// satisfies a clause when each of its regexps either matches somewhere in
+// the file or, if it is negated, matches nowhere. Multiline regexps are
+// matched against the whole file, as grepBlocksBuf does, rather than a line
+// at a time.
+func satisfiedClauses(ctx context.Context, buf []byte, clauses [][]*compiledTerm, multiline bool) ([]int, error) {
+	var satisfied []int
+	for i, clause := range clauses {
+		ok := true
+		for _, t := range clause {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			var found bool
+			if multiline {
+				found = len(t.re.FindAllIndex(buf, 1)) > 0
+			} else {
+				found = t.re.Match(buf, true, true) >= 0
+			}
+			if found == t.not {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			satisfied = append(satisfied, i)
+		}
+	}
+	return satisfied, nil
 }
 
 func (g *grepper) fillFrom(r io.Reader) ([]byte, error) {
@@ -132,8 +214,9 @@ func firstNLines(buf []byte, n int) [][]byte {
 // in memory to do the grep. Fortunately, we limit the size of files that get indexed anyway. 10M files tend
 // to not be source code.
 func (g *grepper) grep2(
+	ctx context.Context,
 	r io.Reader,
-	re *regexp.Regexp,
+	re matcher,
 	nctx int,
 	fn func(line []byte, lineno int, before [][]byte, after [][]byte) (bool, error)) error {
 
@@ -142,24 +225,45 @@ func (g *grepper) grep2(
 		return err
 	}
 
-	lineno := 0
+	return grep2Buf(ctx, buf, nil, re, nctx, fn)
+}
+
+// The greps of a buffer give up with the error of ctx as soon as it is
+// done, checking it before each match so that a file with many matches
+// does not keep an abandoned search running. They are given where each
+// line of the buffer starts when it is known, or nil.
+func grep2Buf(
+	ctx context.Context,
+	buf []byte,
+	lines []int,
+	re matcher,
+	nctx int,
+	fn func(line []byte, lineno int, before [][]byte, after [][]byte) (bool, error)) error {
+
+	lc := lineCounter{buf: buf, starts: lines}
+	pos := 0 // offset of the first line after the last match
 	for {
-		if len(buf) == 0 {
+		if pos == len(buf) {
 			return nil
 		}
 
-		m := re.Match(buf, true, true)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		m := re.Match(buf[pos:], true, true)
 		if m < 0 {
 			return nil
 		}
+		m += pos
 
 		// start of matched line.
-		str := bytes.LastIndex(buf[:m], nl) + 1
+		str := bytes.LastIndex(buf[pos:m], nl) + 1 + pos
 
 		//end of previous line
 		endl := str - 1
-		if endl < 0 {
-			endl = 0
+		if endl < pos {
+			endl = pos
 		}
 
 		//end of current line
@@ -168,12 +272,10 @@ func (g *grepper) grep2(
 			end = len(buf)
 		}
 
-		lineno += countLines(buf[:str])
-
 		more, err := fn(
 			bytes.TrimRight(buf[str:end], "\n"),
-			lineno+1,
-			lastNLines(buf[:endl], nctx),
+			lc.lineAt(str)+1,
+			lastNLines(buf[pos:endl], nctx),
 			firstNLines(buf[end:], nctx))
 		if err != nil {
 			return err
@@ -182,11 +284,100 @@ func (g *grepper) grep2(
 			return nil
 		}
 
-		lineno++
-		buf = buf[end:]
+		pos = end
 	}
 }
 
+// A grep for matches that may span several lines. Each match is reported
+// with all of the lines it covers and the line number of the first of them.
+// Matches that start on a line already covered by a previous match are
+// folded into that match. Context is computed around the whole block.
+func (g *grepper) grepBlocks(
+	ctx context.Context,
+	r io.Reader,
+	re matcher,
+	nctx int,
+	fn func(lines [][]byte, lineno int, before [][]byte, after [][]byte) (bool, error)) error {
+
+	buf, err := g.fillFrom(r)
+	if err != nil {
+		return err
+	}
+
+	return grepBlocksBuf(ctx, buf, nil, re, nctx, fn)
+}
+
+func grepBlocksBuf(
+	ctx context.Context,
+	buf []byte,
+	lines []int,
+	re matcher,
+	nctx int,
+	fn func(lines [][]byte, lineno int, before [][]byte, after [][]byte) (bool, error)) error {
+
+	lc := lineCounter{buf: buf, starts: lines}
+	pos := 0 // offset of the first line not yet covered by a block
+	for _, m := range re.FindAllIndex(buf, -1) {
+		if m[0] < pos {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// there is no line after a trailing newline.
+		if m[0] == len(buf) && m[0] > 0 && buf[m[0]-1] == '\n' {
+			return nil
+		}
+
+		// start of the first matched line.
+		str := bytes.LastIndex(buf[:m[0]], nl) + 1
+
+		// the last byte that belongs to the match; a match ending in a
+		// newline does not extend onto the following line.
+		last := m[0]
+		if m[1] > m[0] {
+			last = m[1] - 1
+		}
+
+		// end of the last matched line.
+		end := len(buf)
+		if i := bytes.IndexByte(buf[last:], '\n'); i >= 0 {
+			end = last + i
+		}
+
+		//end of previous line
+		endl := str - 1
+		if endl < 0 {
+			endl = 0
+		}
+
+		more, err := fn(
+			bytes.Split(buf[str:end], nl),
+			lc.lineAt(str)+1,
+			lastNLines(buf[:endl], nctx),
+			firstNLines(buf[clampLen(end+1, buf):], nctx))
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+
+		pos = clampLen(end+1, buf)
+	}
+
+	return nil
+}
+
+func clampLen(n int, buf []byte) int {
+	if n > len(buf) {
+		return len(buf)
+	}
+	return n
+}
+
 // This nonsense is adapted from https://code.google.com/p/codesearch/source/browse/regexp/match.go#399
 // and I assume it is a mess to make it faster, but I would like to try a much simpler cleaner version.
 func (g *grepper) grep(r io.Reader, re *regexp.Regexp, fn func(line []byte, lineno int) (bool, error)) error {
@@ -2,6 +2,7 @@ package index
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"strings"
 	"testing"
@@ -183,7 +184,7 @@ func assertGrepTest(t *testing.T, buf []byte, exp string, expects []*match) {
 
 	var g grepper
 	var m []*match
-	if err := g.grep2(bytes.NewBuffer(buf), re, 0,
+	if err := g.grep2(context.Background(), bytes.NewBuffer(buf), re, 0,
 		func(line []byte, lineno int, before [][]byte, after [][]byte) (bool, error) {
 			m = append(m, aMatch(string(line), lineno))
 			return true, nil
@@ -227,6 +228,39 @@ func TestGrep(t *testing.T) {
 	})
 }
 
+func assertLiteralGrepTest(t *testing.T, buf []byte, lit string, expects []*match) {
+	var g grepper
+	var m []*match
+	if err := g.grep2(context.Background(), bytes.NewBuffer(buf), &literalMatcher{[]byte(lit)}, 0,
+		func(line []byte, lineno int, before [][]byte, after [][]byte) (bool, error) {
+			m = append(m, aMatch(string(line), lineno))
+			return true, nil
+		}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	assertMatchesMatch(t, m, expects)
+}
+
+func TestLiteralGrep(t *testing.T) {
+	assertLiteralGrepTest(t, subjA, "th", []*match{
+		aMatch("third", 3),
+		aMatch("fourth", 4),
+		aMatch("fifth", 5),
+		aMatch("sixth", 6),
+	})
+
+	assertLiteralGrepTest(t, subjC, "ba", []*match{
+		aMatch("bar", 6),
+		aMatch("baz", 8),
+	})
+
+	assertLiteralGrepTest(t, []byte("f(x[0])\nf(x)\n"), "f(x[0])", []*match{
+		aMatch("f(x[0])", 1),
+	})
+}
+
 func assertContextTest(t *testing.T, buf []byte, exp string, ctx int, expectsBefore [][]string, expectsAfter [][]string) {
 	re, err := regexp.Compile(exp)
 	if err != nil {
@@ -237,7 +271,7 @@ func assertContextTest(t *testing.T, buf []byte, exp string, ctx int, expectsBef
 	var gotBefore [][][]byte
 	var gotAfter [][][]byte
 	var g grepper
-	if err := g.grep2(bytes.NewBuffer(buf), re, ctx,
+	if err := g.grep2(context.Background(), bytes.NewBuffer(buf), re, ctx,
 		func(line []byte, lineno int, before [][]byte, after [][]byte) (bool, error) {
 			gotBefore = append(gotBefore, before)
 			gotAfter = append(gotAfter, after)
@@ -292,3 +326,159 @@ func TestContext(t *testing.T) {
 			[]string{"second", "third"},
 		})
 }
+
+type block struct {
+	lines  []string
+	no     int
+	before []string
+	after  []string
+}
+
+func assertBlocksTest(t *testing.T, buf []byte, exp string, ctx int, expects []*block) {
+	re, err := regexp.Compile(exp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var g grepper
+	var got []*block
+	if err := g.grepBlocks(context.Background(), bytes.NewBuffer(buf), re, ctx,
+		func(lines [][]byte, lineno int, before [][]byte, after [][]byte) (bool, error) {
+			got = append(got, &block{
+				lines:  toStrings(lines),
+				no:     lineno,
+				before: toStrings(before),
+				after:  toStrings(after),
+			})
+			return true, nil
+		}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(got) != len(expects) {
+		t.Errorf("expected %d blocks for %s, got %d", len(expects), exp, len(got))
+		return
+	}
+
+	for i, b := range expects {
+		if formatLines(b.lines) != formatLines(got[i].lines) || b.no != got[i].no ||
+			formatLines(b.before) != formatLines(got[i].before) ||
+			formatLines(b.after) != formatLines(got[i].after) {
+			t.Errorf("block %d for %s: expected %s:%d %s/%s, got %s:%d %s/%s", i, exp,
+				formatLines(b.lines), b.no, formatLines(b.before), formatLines(b.after),
+				formatLines(got[i].lines), got[i].no, formatLines(got[i].before), formatLines(got[i].after))
+		}
+	}
+}
+
+func TestGrepBlocks(t *testing.T) {
+	assertBlocksTest(t, subjA, `second\nthird`, 1, []*block{
+		{[]string{"second", "third"}, 2, []string{"first"}, []string{"fourth"}},
+	})
+
+	// the second match starts on a line that is already covered.
+	assertBlocksTest(t, subjA, `(rd|th)\nf`, 0, []*block{
+		{[]string{"third", "fourth"}, 3, []string{}, []string{}},
+	})
+
+	// a single line match behaves like grep2
+	assertBlocksTest(t, subjA, `s`, 0, []*block{
+		{[]string{"first"}, 1, []string{}, []string{}},
+		{[]string{"second"}, 2, []string{}, []string{}},
+		{[]string{"sixth"}, 6, []string{}, []string{}},
+	})
+
+	// a match that ends with a newline stays on its line
+	assertBlocksTest(t, subjC, `bar\n`, 0, []*block{
+		{[]string{"bar"}, 6, []string{}, []string{}},
+	})
+
+	assertBlocksTest(t, subjC, `foo\nbar\n\nbaz`, 1, []*block{
+		{[]string{"foo", "bar", "", "baz"}, 5, []string{""}, []string{}},
+	})
+}
+
+func TestGrepCachedLines(t *testing.T) {
+	re, err := regexp.Compile(`^|a`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the lines of a cached file are numbered the same as those counted.
+	f := newCachedFile("c", subjC)
+	numbers := func(lines []int) []int {
+		var nos []int
+		if err := grep2Buf(context.Background(), f.data, lines, re, 1,
+			func(line []byte, lineno int, before [][]byte, after [][]byte) (bool, error) {
+				nos = append(nos, lineno)
+				return true, nil
+			}); err != nil {
+			t.Fatal(err)
+		}
+		if err := grepBlocksBuf(context.Background(), f.data, lines, re, 1,
+			func(lines [][]byte, lineno int, before [][]byte, after [][]byte) (bool, error) {
+				nos = append(nos, -lineno)
+				return true, nil
+			}); err != nil {
+			t.Fatal(err)
+		}
+		return nos
+	}
+
+	// grep2 matches ^ on every line, the blocks of the whole buffer only
+	// on the first.
+	expected := "[1 2 3 4 5 6 7 8 -1 -6 -8]"
+	if got := fmt.Sprint(numbers(nil)); got != expected {
+		t.Errorf("lines were numbered %s, expected %s", got, expected)
+	}
+	if got := fmt.Sprint(numbers(f.lines)); got != expected {
+		t.Errorf("cached lines were numbered %s, expected %s", got, expected)
+	}
+}
+
+func TestGrepCancelledInFile(t *testing.T) {
+	buf := []byte(strings.Repeat("match\n", 1000))
+
+	re, err := regexp.Compile("match")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var g grepper
+	n := 0
+	err = g.grep2(ctx, bytes.NewBuffer(buf), re, 0,
+		func(line []byte, lineno int, before [][]byte, after [][]byte) (bool, error) {
+			n++
+			if n == 10 {
+				cancel()
+			}
+			return true, nil
+		})
+	if err != context.Canceled {
+		t.Errorf("grep2 returned %v after being cancelled", err)
+	}
+	if n != 10 {
+		t.Errorf("grep2 went on to %d matches after being cancelled at 10", n)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	n = 0
+	err = g.grepBlocks(ctx, bytes.NewBuffer(buf), re, 0,
+		func(lines [][]byte, lineno int, before [][]byte, after [][]byte) (bool, error) {
+			n++
+			if n == 10 {
+				cancel()
+			}
+			return true, nil
+		})
+	if err != context.Canceled || n != 10 {
+		t.Errorf("grepBlocks returned %v after %d matches, cancelled at 10", err, n)
+	}
+}
@@ -0,0 +1,114 @@
+package index
+
+import (
+	goregexp "regexp"
+	"strings"
+	"unicode"
+)
+
+// The longest word that is written in upper case when it is part of a
+// camel case identifier, as in UserID.
+const maxAcronymLen = 2
+
+// Split an identifier into its lower case words, breaking on separators,
+// on changes from lower to upper case and before the last letter of a run of
+// upper case letters followed by lower case ones, as in HTTPServer.
+func identifierWords(s string) []string {
+	var words []string
+	var word []rune
+
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+
+	rs := []rune(s)
+	for i, r := range rs {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+			continue
+		case unicode.IsUpper(r) && i > 0:
+			prev := rs[i-1]
+			next := i+1 < len(rs) && unicode.IsLower(rs[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && next) {
+				flush()
+			}
+		}
+		word = append(word, r)
+	}
+	flush()
+
+	return words
+}
+
+func title(w string) string {
+	rs := []rune(w)
+	rs[0] = unicode.ToUpper(rs[0])
+	return string(rs)
+}
+
+// Join the words in camel case, starting with an upper case letter if
+// pascal is set. Short words are all upper case if acronyms is set.
+func camelCase(words []string, pascal, acronyms bool) string {
+	var b strings.Builder
+	for i, w := range words {
+		switch {
+		case i == 0 && !pascal:
+			b.WriteString(w)
+		case acronyms && len(w) <= maxAcronymLen:
+			b.WriteString(strings.ToUpper(w))
+		default:
+			b.WriteString(title(w))
+		}
+	}
+	return b.String()
+}
+
+// Expand an identifier into its spellings in the common casing and separator
+// styles, starting with the identifier itself: userId, UserId, userID,
+// UserID, user_id, USER_ID and user-id.
+func ExpandIdentifier(ident string) []string {
+	words := identifierWords(ident)
+	if len(words) == 0 {
+		return []string{ident}
+	}
+
+	upper := make([]string, len(words))
+	for i, w := range words {
+		upper[i] = strings.ToUpper(w)
+	}
+
+	variants := []string{
+		ident,
+		camelCase(words, false, false),
+		camelCase(words, true, false),
+		camelCase(words, false, true),
+		camelCase(words, true, true),
+		strings.Join(words, "_"),
+		strings.Join(upper, "_"),
+		strings.Join(words, "-"),
+	}
+
+	seen := map[string]bool{}
+	var res []string
+	for _, v := range variants {
+		if !seen[v] {
+			seen[v] = true
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// A regexp matching any of the spellings of an identifier. Being a plain
+// alternation, the trigram query built from it is an OR over the spellings.
+func identifierPattern(ident string) string {
+	variants := ExpandIdentifier(ident)
+	for i, v := range variants {
+		variants[i] = goregexp.QuoteMeta(v)
+	}
+	return "(?:" + strings.Join(variants, "|") + ")"
+}
@@ -0,0 +1,48 @@
+package index
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/it-projects-llc/hound/codesearch/regexp"
+)
+
+func TestIdentifierWords(t *testing.T) {
+	tests := map[string][]string{
+		"userId":     {"user", "id"},
+		"UserID":     {"user", "id"},
+		"user_id":    {"user", "id"},
+		"USER-ID":    {"user", "id"},
+		"HTTPServer": {"http", "server"},
+		"utf8Decode": {"utf8", "decode"},
+		"__":         nil,
+	}
+
+	for in, out := range tests {
+		if words := identifierWords(in); !reflect.DeepEqual(words, out) {
+			t.Errorf("identifierWords(%q): expected %v, got %v", in, out, words)
+		}
+	}
+}
+
+func TestExpandIdentifier(t *testing.T) {
+	expected := []string{"user_id", "userId", "UserId", "userID", "UserID", "USER_ID", "user-id"}
+	if got := ExpandIdentifier("user_id"); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	re, err := regexp.Compile(GetRegexpPatternFor("userId", &SearchOptions{IdentStyle: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{"userId", "UserID", "user_id", "USER_ID", "user-id"} {
+		if re.MatchString(s, true, true) < 0 {
+			t.Errorf("expected a match for %q", s)
+		}
+	}
+
+	if re.MatchString("user.id", true, true) >= 0 {
+		t.Error("expected no match for user.id")
+	}
+}
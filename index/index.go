@@ -1,14 +1,18 @@
 package index
 
 import (
-	"compress/gzip"
+	"context"
 	"encoding/gob"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
+	goregexp "regexp"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
@@ -23,6 +27,13 @@ const (
 	filePeekSize             = 2048
 )
 
+// Reasons a search can stop before it has seen every candidate file. These
+// are reported in SearchResponse.Reason when Truncated is set.
+const (
+	ReasonMatchLimit = "Search exceeded the limit on matches."
+	ReasonTimeLimit  = "Search exceeded the time limit."
+)
+
 const (
 	reasonDotFile     = "Dot files are excluded."
 	reasonInvalidMode = "Invalid file mode."
@@ -32,40 +43,243 @@ const (
 type Index struct {
 	Ref *IndexRef
 	idx *index.Index
+	raw RawStore
 	lck sync.RWMutex
+
+	// The contents of recently read files, nil unless SetFileCacheSize
+	// turned caching on.
+	cache *fileCache
+
+	symOnce    sync.Once
+	syms       []*Symbol
+	symsByFile map[string][]*Symbol
+	symErr     error
+
+	langOnce sync.Once
+	langs    map[string]string
+	langErr  error
 }
 
 type IndexOptions struct {
 	ExcludeDotFiles bool
 	SpecialFiles    []string
+
+	// Find the symbols defined in the indexed files, using the given parsers
+	// or DefaultSymbolParsers if there are none, and ctags for the files the
+	// parsers do not handle.
+	Symbols       bool
+	SymbolParsers []SymbolParser
 }
 
 type SearchOptions struct {
 	IgnoreCase     bool
+	Literal        bool
+	WholeWord      bool
+	Multiline      bool
 	LinesOfContext uint
 	FileRegexp     string
-	Offset         int
-	Limit          int
+	// Files whose path matches ExcludeFileRegexp are never opened.
+	ExcludeFileRegexp string
+	Offset            int
+	Limit             int
+
+	// Budgets for the search. Once either is exhausted, the matches found so
+	// far are returned and the response is marked as truncated. A zero
+	// MaxMatches means matchLimit, a zero Timeout means no time limit.
+	MaxMatches int
+	Timeout    time.Duration
+
+	// Count the matches of every file, grouped into Facets by extension and
+	// by the first FacetDepth directories of the path, one by default.
+	// CountOnly counts the matches without returning any of them. Matches
+	// are counted past MaxMatches, only the time limit stops a counting
+	// search.
+	Facets     bool
+	FacetDepth int
+	CountOnly  bool
+
+	// Paged searches go through the files in path order, skipping every
+	// path up to and including After, and stop as soon as a file with
+	// matches is found after Limit files have been collected. HasMore
+	// tells whether such a file was found. A page that ended part way
+	// through the matches of After resumes within it instead, skipping
+	// the first AfterMatches of its matches.
+	Paged        bool
+	After        string
+	AfterMatches int
+
+	// Rank the files by how likely they are to be what was searched for,
+	// see scoreFile. Ranking needs every file with matches, so Offset and
+	// Limit apply to the ranked files and the match budget to all of them.
+	// Paged searches are never ranked.
+	Rank bool
+
+	// Match the pattern as an identifier in any casing and separator style,
+	// see ExpandIdentifier. The identifier is taken literally.
+	IdentStyle bool
+
+	// Only match the lines defining a symbol whose name matches. This needs
+	// an index built with symbols.
+	DefinitionsOnly bool
+
+	// Only search files in one of these languages, see Languages.
+	Languages []string
+
+	// The number of candidate files grepped at once. Results are the same
+	// whatever the number, zero means one.
+	Workers int
+}
+
+// A pattern in a boolean search. A file satisfies a term when it contains
+// a match for Pattern or, if Not is set, when it contains none.
+type Term struct {
+	Pattern string
+	Not     bool
+}
+
+// A conjunction of terms. A file satisfies a clause when it satisfies every
+// one of its terms. Each clause needs at least one term that is not negated.
+type Clause []Term
+
+type compiledTerm struct {
+	re  *regexp.Regexp
+	not bool
 }
 
 type Match struct {
 	Line       string
 	LineNumber int
+	Ranges     []*Range `json:",omitempty"`
 	Before     []string
 	After      []string
+
+	// Set only for matches that span several lines. Lines holds every line
+	// of the match, starting with Line, through EndLineNumber.
+	Lines         []string `json:",omitempty"`
+	EndLineNumber int      `json:",omitempty"`
+}
+
+// The location of a single match within a line. Start and End are byte
+// offsets into the line, RuneStart and RuneEnd are the same positions
+// counted in runes.
+type Range struct {
+	Start     int
+	End       int
+	RuneStart int
+	RuneEnd   int
 }
 
 type SearchResponse struct {
 	Matches        []*FileMatch
 	FilesWithMatch int
 	FilesOpened    int           `json:"-"`
+	FilesCached    int           `json:"-"`
 	Duration       time.Duration `json:"-"`
 	Revision       string
+	Truncated      bool   `json:",omitempty"`
+	Reason         string `json:",omitempty"`
+
+	// Set when the search stopped before grepping every candidate file, in
+	// which case FilesWithMatch only counts the files grepped so far.
+	FilesWithMatchLowerBound bool `json:",omitempty"`
+
+	// Only set by paged searches. After and AfterMatches are where the
+	// next page resumes, see SearchOptions.
+	HasMore      bool   `json:",omitempty"`
+	After        string `json:",omitempty"`
+	AfterMatches int    `json:",omitempty"`
+
+	// Set when the response was served from the result cache of the repo
+	// instead of searching its index again.
+	Cached bool `json:",omitempty"`
+
+	// Only counted by faceted and count only searches.
+	LinesWithMatch int     `json:",omitempty"`
+	Facets         *Facets `json:",omitempty"`
+}
+
+// The number of files with matches and the number of matches in them, which
+// are lines unless the search is multiline.
+type FacetCount struct {
+	Files int
+	Lines int
+}
+
+// Counts of matches grouped by file extension and by leading directories.
+// Files without an extension or directory count towards the empty string.
+type Facets struct {
+	Extensions map[string]*FacetCount
+	Dirs       map[string]*FacetCount
+}
+
+func newFacets() *Facets {
+	return &Facets{
+		Extensions: map[string]*FacetCount{},
+		Dirs:       map[string]*FacetCount{},
+	}
+}
+
+func addToFacet(m map[string]*FacetCount, key string, files, lines int) {
+	c := m[key]
+	if c == nil {
+		c = &FacetCount{}
+		m[key] = c
+	}
+	c.Files += files
+	c.Lines += lines
+}
+
+// Count the lines matched in the named file.
+func (f *Facets) add(name string, depth, lines int) {
+	addToFacet(f.Extensions, strings.TrimPrefix(filepath.Ext(name), "."), 1, lines)
+	addToFacet(f.Dirs, leadingDirs(name, depth), 1, lines)
+}
+
+// Merge the counts of o into f.
+func (f *Facets) Merge(o *Facets) {
+	for k, c := range o.Extensions {
+		addToFacet(f.Extensions, k, c.Files, c.Lines)
+	}
+	for k, c := range o.Dirs {
+		addToFacet(f.Dirs, k, c.Files, c.Lines)
+	}
+}
+
+// The first depth directories of a slash separated path.
+func leadingDirs(name string, depth int) string {
+	dirs := strings.Split(name, "/")
+	dirs = dirs[:len(dirs)-1]
+	if len(dirs) > depth {
+		dirs = dirs[:depth]
+	}
+	return strings.Join(dirs, "/")
+}
+
+type Explanation struct {
+	// The trigram query selecting candidate files and whether it selects
+	// every file in the index.
+	Query string
+	All   bool
+
+	// The number of files in the index, the number selected by Query, the
+	// number left to open after the file filters and the number that match.
+	Files       int
+	Candidates  int
+	FilesOpened int
+	Matched     int
+
+	Revision  string
+	Truncated bool   `json:",omitempty"`
+	Reason    string `json:",omitempty"`
 }
 
 type FileMatch struct {
 	Filename string
 	Matches  []*Match
+	Language string `json:",omitempty"`
+
+	// Only set by ranked searches.
+	Score float64 `json:",omitempty"`
 }
 
 type ExcludedFile struct {
@@ -78,6 +292,21 @@ type IndexRef struct {
 	Rev  string
 	Time time.Time
 	dir  string
+
+	// How the contents of the indexed files are stored.
+	RawFormat string
+
+	// The checksum of each file of the index by its name, see Verify.
+	// Indexes built before checksums were recorded have none.
+	Checksums map[string]string
+
+	// The size and modification time of each file of the index by its
+	// name, recorded along with the checksums.
+	Stamps map[string]fileStamp
+
+	// The options the index was built with, see IndexOptions.key. Update
+	// only reuses the files of an index built with the same options.
+	Options string
 }
 
 func (r *IndexRef) Dir() string {
@@ -95,9 +324,21 @@ func (r *IndexRef) writeManifest() error {
 }
 
 func (r *IndexRef) Open() (*Index, error) {
+	raw, err := openRawStore(r.dir, r.RawFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := index.Open(filepath.Join(r.dir, "tri"))
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+
 	return &Index{
 		Ref: r,
-		idx: index.Open(filepath.Join(r.dir, "tri")),
+		idx: idx,
+		raw: raw,
 	}, nil
 }
 
@@ -108,18 +349,43 @@ func (r *IndexRef) Remove() error {
 func (n *Index) Close() error {
 	n.lck.Lock()
 	defer n.lck.Unlock()
+	n.dropFileCache()
+	n.raw.Close()
 	return n.idx.Close()
 }
 
 func (n *Index) Destroy() error {
 	n.lck.Lock()
 	defer n.lck.Unlock()
+	n.dropFileCache()
+	n.raw.Close()
 	if err := n.idx.Close(); err != nil {
 		return err
 	}
 	return n.Ref.Remove()
 }
 
+// Keep the decompressed contents of the most recently read files in memory,
+// up to max bytes in all. Caching is off when max is zero, as it is for a
+// newly opened index.
+func (n *Index) SetFileCacheSize(max int64) {
+	n.lck.Lock()
+	defer n.lck.Unlock()
+
+	n.dropFileCache()
+	if max > 0 {
+		n.cache = newFileCache(max)
+	}
+}
+
+// Free the cached contents of files, whatever still refers to the cache.
+func (n *Index) dropFileCache() {
+	if n.cache != nil {
+		n.cache.clear()
+		n.cache = nil
+	}
+}
+
 func (n *Index) GetDir() string {
 	return n.Ref.dir
 }
@@ -132,6 +398,47 @@ func toStrings(lines [][]byte) []string {
 	return strs
 }
 
+// Make a Match for the given lines, the first of which is at lineno. Matches
+// on a single line carry the ranges of the matches within that line, matches
+// spanning several lines carry all of their lines instead.
+func newMatch(m matcher, lines [][]byte, lineno int, before [][]byte, after [][]byte) *Match {
+	r := &Match{
+		Line:       string(lines[0]),
+		LineNumber: lineno,
+		Before:     toStrings(before),
+		After:      toStrings(after),
+	}
+
+	if len(lines) == 1 {
+		r.Ranges = rangesFor(m, lines[0])
+	} else {
+		r.Lines = toStrings(lines)
+		r.EndLineNumber = lineno + len(lines) - 1
+	}
+
+	return r
+}
+
+// Find the ranges of all matches in line.
+func rangesFor(m matcher, line []byte) []*Range {
+	idxs := m.FindAllIndex(line, -1)
+	if len(idxs) == 0 {
+		return nil
+	}
+
+	ranges := make([]*Range, len(idxs))
+	for i, idx := range idxs {
+		rs := utf8.RuneCount(line[:idx[0]])
+		ranges[i] = &Range{
+			Start:     idx[0],
+			End:       idx[1],
+			RuneStart: rs,
+			RuneEnd:   rs + utf8.RuneCount(line[idx[0]:idx[1]]),
+		}
+	}
+	return ranges
+}
+
 func GetRegexpPattern(pat string, ignoreCase bool) string {
 	if ignoreCase {
 		return "(?i)(?m)" + pat
@@ -139,26 +446,169 @@ func GetRegexpPattern(pat string, ignoreCase bool) string {
 	return "(?m)" + pat
 }
 
-func (n *Index) Search(pat string, opt *SearchOptions) (*SearchResponse, error) {
+// Get the regular expression that is matched for pat under the given search
+// options. Literal patterns are quoted so they match only themselves,
+// identifiers are expanded into all of their spellings and whole word
+// patterns are surrounded by word boundaries, which the trigram query
+// ignores.
+func GetRegexpPatternFor(pat string, opt *SearchOptions) string {
+	if opt.IdentStyle {
+		pat = identifierPattern(pat)
+	} else if opt.Literal {
+		pat = goregexp.QuoteMeta(pat)
+	}
+	if opt.WholeWord {
+		pat = `\b(?:` + pat + `)\b`
+	}
+	return GetRegexpPattern(pat, opt.IgnoreCase)
+}
+
+// Get the regular expression matching any of the terms of the clauses that
+// are not negated. It is used to find and highlight the matched lines of
+// files that satisfy a boolean search.
+func GetRegexpPatternForClauses(clauses []Clause, opt *SearchOptions) string {
+	var alts []string
+	for _, clause := range clauses {
+		for _, t := range clause {
+			if !t.Not {
+				alts = append(alts, "(?:"+GetRegexpPatternFor(t.Pattern, opt)+")")
+			}
+		}
+	}
+	return strings.Join(alts, "|")
+}
+
+// Choose the matcher used to grep files for pat. Case sensitive literals
+// that fit on a single line can use a plain substring search, everything
+// else goes through the regexp.
+func matcherFor(pat string, re *regexp.Regexp, opt *SearchOptions) matcher {
+	if opt.Literal && !opt.IgnoreCase && !opt.WholeWord && !opt.IdentStyle && pat != "" && !strings.Contains(pat, "\n") {
+		return &literalMatcher{[]byte(pat)}
+	}
+	return re
+}
+
+// Search the index for the given pattern. The search stops early with the
+// context's error when ctx is cancelled. If the deadline of ctx expires or
+// one of the budgets in opt is exhausted, the results collected so far are
+// returned with Truncated set.
+func (n *Index) Search(ctx context.Context, pat string, opt *SearchOptions) (*SearchResponse, error) {
+	return n.SearchClauses(ctx, []Clause{{{Pattern: pat}}}, opt)
+}
+
+// Compile the terms of the clauses and build the trigram query for the
+// files that may satisfy any of them. Only terms that are not negated can
+// narrow down the candidates, negated terms are checked while grepping.
+func compileClauses(clauses []Clause, opt *SearchOptions) ([][]*compiledTerm, *index.Query, error) {
+	var q *index.Query
+	compiled := make([][]*compiledTerm, len(clauses))
+	for i, clause := range clauses {
+		var cq *index.Query
+		for _, t := range clause {
+			re, err := regexp.Compile(GetRegexpPatternFor(t.Pattern, opt))
+			if err != nil {
+				return nil, nil, err
+			}
+			compiled[i] = append(compiled[i], &compiledTerm{re, t.Not})
+
+			if t.Not {
+				continue
+			}
+
+			tq := index.RegexpQuery(re.Syntax)
+			if cq == nil {
+				cq = tq
+			} else {
+				cq = cq.And(tq)
+			}
+		}
+
+		if cq == nil {
+			return nil, nil, errors.New("each clause needs a pattern that is not negated")
+		}
+
+		if q == nil {
+			q = cq
+		} else {
+			q = q.Or(cq)
+		}
+	}
+
+	if q == nil {
+		return nil, nil, errors.New("no patterns to search for")
+	}
+
+	return compiled, q, nil
+}
+
+// Search the index for files satisfying any of the clauses. Matched lines
+// are the lines matching any term that is not negated. A search for a
+// single pattern is a single clause holding a single term.
+func (n *Index) SearchClauses(ctx context.Context, clauses []Clause, opt *SearchOptions) (*SearchResponse, error) {
 	startedAt := time.Now()
 
+	if opt.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opt.Timeout)
+		defer cancel()
+	}
+
+	maxMatches := opt.MaxMatches
+	if maxMatches <= 0 {
+		maxMatches = matchLimit
+	}
+
 	n.lck.RLock()
 	defer n.lck.RUnlock()
 
-	re, err := regexp.Compile(GetRegexpPattern(pat, opt.IgnoreCase))
+	m, _, q, err := searchMatcher(clauses, opt)
 	if err != nil {
 		return nil, err
 	}
 
 	var (
-		g                grepper
 		results          []*FileMatch
 		filesOpened      int
+		filesCached      int
 		filesFound       int
 		filesCollected   int
 		matchesCollected int
+		linesFound       int
+		reason           string
+		facets           *Facets
 	)
 
+	// counting searches grep every line of every file, whether or not the
+	// matches are collected.
+	counting := opt.Facets || opt.CountOnly
+	if opt.Facets {
+		facets = newFacets()
+	}
+
+	depth := opt.FacetDepth
+	if depth <= 0 {
+		depth = 1
+	}
+
+	// ranked searches collect every file and page through them afterwards.
+	rank := opt.Rank && !opt.Paged
+	offset, limit := opt.Offset, opt.Limit
+	if rank {
+		offset, limit = 0, 0
+	}
+
+	var symsByFile map[string][]*Symbol
+	if opt.DefinitionsOnly {
+		if _, symsByFile, err = n.loadSymbols(); err != nil {
+			return nil, err
+		}
+	}
+
+	langs, err := n.loadLanguages()
+	if err != nil {
+		return nil, err
+	}
+
 	var fre *regexp.Regexp
 	if opt.FileRegexp != "" {
 		fre, err = regexp.Compile(opt.FileRegexp)
@@ -167,63 +617,267 @@ func (n *Index) Search(pat string, opt *SearchOptions) (*SearchResponse, error)
 		}
 	}
 
-	files := n.idx.PostingQuery(index.RegexpQuery(re.Syntax))
-	for _, file := range files {
-		var matches []*Match
-		name := n.idx.Name(file)
-		hasMatch := false
+	var xre *regexp.Regexp
+	if opt.ExcludeFileRegexp != "" {
+		xre, err = regexp.Compile(opt.ExcludeFileRegexp)
+		if err != nil {
+			return nil, err
+		}
+	}
 
+	files, err := n.idx.PostingQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.Paged {
+		files, err = n.inPathOrder(files, opt.After, opt.AfterMatches > 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Is this candidate to be grepped? Returns its language when it is.
+	accept := func(name string) (string, bool) {
 		// reject files that do not match the file pattern
 		if fre != nil && fre.MatchString(name, true, true) < 0 {
-			continue
+			return "", false
 		}
 
-		filesOpened++
-		if err := g.grep2File(filepath.Join(n.Ref.dir, "raw", name), re, int(opt.LinesOfContext),
-			func(line []byte, lineno int, before [][]byte, after [][]byte) (bool, error) {
+		// reject files that match the exclude pattern
+		if xre != nil && xre.MatchString(name, true, true) >= 0 {
+			return "", false
+		}
 
-				hasMatch = true
-				if filesFound < opt.Offset || (opt.Limit > 0 && filesCollected >= opt.Limit) {
-					return false, nil
-				}
+		// reject files in other languages
+		lang := languageIn(langs, name)
+		if len(opt.Languages) > 0 && !containsString(opt.Languages, lang) {
+			return "", false
+		}
+
+		// reject files that define nothing
+		if opt.DefinitionsOnly && len(symsByFile[name]) == 0 {
+			return "", false
+		}
 
-				matchesCollected++
-				matches = append(matches, &Match{
-					Line:       string(line),
-					LineNumber: lineno,
-					Before:     toStrings(before),
-					After:      toStrings(after),
-				})
+		return lang, true
+	}
 
-				if matchesCollected > matchLimit {
-					return false, fmt.Errorf("search exceeds limit on matches: %d", matchLimit)
-				}
+	// no more matches are collected once this is set.
+	var full int32
+	if opt.CountOnly {
+		full = 1
+	}
 
-				return true, nil
-			}); err != nil {
+	// files are only grepped up to their first match while this is set.
+	var skipping int32
+	if offset > 0 {
+		skipping = 1
+	}
+
+	p := n.startGrep(ctx, clauses, opt, files, accept, &grepWorker{
+		raw:        n.raw,
+		opt:        opt,
+		symsByFile: symsByFile,
+		maxMatches: maxMatches,
+		counting:   counting,
+		full:       &full,
+		skipping:   &skipping,
+	})
+	defer p.stop()
+
+	hasMore := false
+	grepped := false
+
+	// where the next page resumes, which stops moving at the first file
+	// whose matches were not all collected.
+	after, afterMatches := opt.After, opt.AfterMatches
+	resumed := false
+	for {
+		f, err := p.next(ctx)
+		if err == nil && f != nil && f.stopped && filesFound >= offset {
+			f, err = p.regrep(ctx, f)
+		}
+
+		if err == context.DeadlineExceeded {
+			reason = ReasonTimeLimit
+			break
+		} else if err != nil {
 			return nil, err
+		} else if f == nil {
+			grepped = true
+			break
 		}
 
-		if !hasMatch {
-			continue
+		filesOpened++
+		if f.cached {
+			filesCached++
+		}
+
+		// files keep their matches as long as no budget or limit is
+		// exhausted, just as if they had been grepped one at a time.
+		var matches []*Match
+		for i := 0; i < f.matched; i++ {
+			if opt.CountOnly || filesFound < offset || (limit > 0 && filesCollected >= limit) {
+				break
+			}
+
+			if matchesCollected >= maxMatches {
+				reason = ReasonMatchLimit
+				break
+			}
+
+			matchesCollected++
+			matches = append(matches, f.matches[i])
+		}
+
+		if f.hasMatch {
+			filesFound++
+			linesFound += f.matched
+			if facets != nil {
+				facets.add(f.name, depth, f.matched)
+			}
+
+			if filesFound >= offset {
+				atomic.StoreInt32(&skipping, 0)
+			}
 		}
 
-		filesFound++
 		if len(matches) > 0 {
 			filesCollected++
 			results = append(results, &FileMatch{
-				Filename: name,
+				Filename: f.name,
 				Matches:  matches,
+				Language: f.lang,
 			})
 		}
+
+		// a paged search is done once a file past the page has a match.
+		if opt.Paged && f.hasMatch && len(matches) == 0 && opt.Limit > 0 && filesCollected >= opt.Limit {
+			hasMore = true
+			break
+		}
+
+		if opt.Paged && !resumed {
+			skipped := 0
+			if f.name == opt.After {
+				skipped = opt.AfterMatches
+			}
+
+			if len(matches) == f.matched {
+				after, afterMatches = f.name, 0
+			} else {
+				if len(matches) > 0 {
+					after, afterMatches = f.name, skipped+len(matches)
+				}
+				resumed = true
+			}
+		}
+
+		if reason != "" && !counting {
+			break
+		}
+
+		if (limit > 0 && filesCollected >= limit) || matchesCollected >= maxMatches {
+			atomic.StoreInt32(&full, 1)
+		}
+	}
+
+	if !counting {
+		linesFound = 0
+	}
+
+	if rank {
+		rankFiles(m, results)
+		results = pageOf(results, opt.Offset, opt.Limit)
 	}
 
 	return &SearchResponse{
 		Matches:        results,
 		FilesWithMatch: filesFound,
 		FilesOpened:    filesOpened,
+		FilesCached:    filesCached,
 		Duration:       time.Now().Sub(startedAt),
 		Revision:       n.Ref.Rev,
+		Truncated:      reason != "",
+		Reason:         reason,
+		HasMore:        hasMore,
+		After:          after,
+		AfterMatches:   afterMatches,
+		LinesWithMatch: linesFound,
+		Facets:         facets,
+
+		FilesWithMatchLowerBound: !grepped,
+	}, nil
+}
+
+// Sort the files by path, dropping those with paths up to after, and after
+// itself unless keepAfter is set.
+func (n *Index) inPathOrder(files []uint32, after string, keepAfter bool) ([]uint32, error) {
+	names := make(map[uint32]string, len(files))
+	res := files[:0]
+	for _, file := range files {
+		name, err := n.idx.Name(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if name > after || keepAfter && name == after {
+			names[file] = name
+			res = append(res, file)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return names[res[i]] < names[res[j]]
+	})
+	return res, nil
+}
+
+// Find the files the trigram query selects as candidates for the clauses.
+func (n *Index) candidates(q *index.Query) (int, int, error) {
+	n.lck.RLock()
+	defer n.lck.RUnlock()
+
+	files, err := n.idx.PostingQuery(q)
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(files), n.idx.NumNames(), nil
+}
+
+// Explain how a search for the clauses runs: the trigram query used to
+// select candidate files, how many files it selects and how many of those
+// turn out to match. Only the first matching file is collected while the
+// rest are merely counted, so explaining a search is cheaper than running it.
+func (n *Index) Explain(ctx context.Context, clauses []Clause, opt *SearchOptions) (*Explanation, error) {
+	_, q, err := compileClauses(clauses, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, files, err := n.candidates(q)
+	if err != nil {
+		return nil, err
+	}
+
+	o := *opt
+	o.Offset, o.Limit = 0, 1
+	res, err := n.SearchClauses(ctx, clauses, &o)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Explanation{
+		Query:       q.String(),
+		All:         q.Op == index.QAll,
+		Files:       files,
+		Candidates:  candidates,
+		FilesOpened: res.FilesOpened,
+		Matched:     res.FilesWithMatch,
+		Revision:    res.Revision,
+		Truncated:   res.Truncated,
+		Reason:      res.Reason,
 	}, nil
 }
 
@@ -278,7 +932,10 @@ func validUTF8IgnoringPartialTrailingRune(p []byte) bool {
 	return true
 }
 
-func addFileToIndex(ix *index.IndexWriter, dst, src, path string) (string, error) {
+// Add a file to the index and its contents to the pack files, writing them
+// to h as they are read. Files the index leaves out are dropped from the
+// pack files again.
+func addFileToIndex(ix *index.IndexWriter, pw *packWriter, src, path string, h io.Writer) (string, error) {
 	rel, err := filepath.Rel(src, path)
 	if err != nil {
 		return "", err
@@ -290,31 +947,13 @@ func addFileToIndex(ix *index.IndexWriter, dst, src, path string) (string, error
 	}
 	defer r.Close()
 
-	dup := filepath.Join(dst, "raw", rel)
-	w, err := os.Create(dup)
+	p, err := pw.Begin(rel)
 	if err != nil {
 		return "", err
 	}
-	defer w.Close()
 
-	g := gzip.NewWriter(w)
-	defer g.Close()
-
-	return ix.Add(rel, io.TeeReader(r, g)), nil
-}
-
-func addDirToIndex(dst, src, path string) error {
-	rel, err := filepath.Rel(src, path)
-	if err != nil {
-		return err
-	}
-
-	if rel == "." {
-		return nil
-	}
-
-	dup := filepath.Join(dst, "raw", rel)
-	return os.Mkdir(dup, os.ModePerm)
+	reason := ix.Add(rel, io.TeeReader(r, io.MultiWriter(p, h)))
+	return reason, p.End(reason == "")
 }
 
 // write the list of excluded files to the given filename.
@@ -337,18 +976,12 @@ func containsString(haystack []string, needle string) bool {
 	return false
 }
 
-func indexAllFiles(opt *IndexOptions, dst, src string) error {
-	ix := index.Create(filepath.Join(dst, "tri"))
-	defer ix.Close()
-
+// Index the files of src into dst. When prev is given, the files that have
+// not changed since prev was built are reused and only the rest are indexed,
+// see Update.
+func indexAllFiles(opt *IndexOptions, dst, src string, prev *IndexRef, sums map[string]string) error {
 	excluded := []*ExcludedFile{}
-
-	// Make a file to store the excluded files for this repo
-	fileHandle, err := os.Create(filepath.Join(dst, "excluded_files.json"))
-	if err != nil {
-		return err
-	}
-	defer fileHandle.Close()
+	var names []string
 
 	if err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 		name := info.Name()
@@ -379,7 +1012,7 @@ func indexAllFiles(opt *IndexOptions, dst, src string) error {
 		}
 
 		if info.IsDir() {
-			return addDirToIndex(dst, src, path)
+			return nil
 		}
 
 		if info.Mode()&os.ModeType != 0 {
@@ -390,31 +1023,78 @@ func indexAllFiles(opt *IndexOptions, dst, src string) error {
 			return nil
 		}
 
-		txt, err := isTextFile(path)
+		names = append(names, rel)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// an index can only be merged with another when its files were added
+	// in sorted order.
+	sort.Strings(names)
+
+	var prevFiles map[string]*fileState
+	if prev != nil {
+		var err error
+		prevFiles, err = readFileStates(filepath.Join(prev.dir, filesFilename))
 		if err != nil {
 			return err
 		}
 
-		if !txt {
-			excluded = append(excluded, &ExcludedFile{
-				rel,
-				reasonNotText,
-			})
-			return nil
+		// indexes built before files were hashed or packed, or with other
+		// options, are not reused.
+		if prevFiles == nil || prev.RawFormat != rawFormatPack || prev.Options != opt.key() {
+			prev = nil
 		}
+	}
 
-		reasonForExclusion, err := addFileToIndex(ix, dst, src, path)
+	var prevRaw *packStore
+	if prev != nil {
+		var err error
+		prevRaw, err = openPackStore(prev.dir)
 		if err != nil {
 			return err
 		}
-		if reasonForExclusion != "" {
-			excluded = append(excluded, &ExcludedFile{rel, reasonForExclusion})
-		}
+		defer prevRaw.Close()
+	}
 
-		return nil
-	}); err != nil {
+	pw := createPackWriter(dst)
+
+	files, changed, err := reuseFiles(prevFiles, prevRaw, pw, src, names)
+	if err != nil {
+		return err
+	}
+
+	if err := indexChangedFiles(dst, src, pw, prev, prevFiles, files, changed, sums); err != nil {
+		return err
+	}
+
+	if err := pw.Close(); err != nil {
 		return err
 	}
+	for name, sum := range pw.sums {
+		sums[name] = sum
+	}
+
+	var indexed []string
+	langs := map[string]string{}
+	for _, rel := range names {
+		st := files[rel]
+		if st.Excluded != "" {
+			excluded = append(excluded, &ExcludedFile{rel, st.Excluded})
+			continue
+		}
+
+		indexed = append(indexed, rel)
+
+		lang, err := detectLanguage(filepath.Join(src, rel))
+		if err != nil {
+			return err
+		}
+		if lang != "" {
+			langs[rel] = lang
+		}
+	}
 
 	if err := writeExcludedFilesJson(
 		filepath.Join(dst, excludedFileJsonFilename),
@@ -422,9 +1102,26 @@ func indexAllFiles(opt *IndexOptions, dst, src string) error {
 		return err
 	}
 
-	ix.Flush()
+	if err := writeLanguages(
+		filepath.Join(dst, languagesFilename),
+		langs); err != nil {
+		return err
+	}
 
-	return nil
+	if opt.Symbols {
+		syms, err := reuseSymbols(opt, prev, src, indexed, changed)
+		if err != nil {
+			return err
+		}
+
+		if err := writeSymbols(
+			filepath.Join(dst, symbolsFilename),
+			syms); err != nil {
+			return err
+		}
+	}
+
+	return writeFileStates(filepath.Join(dst, filesFilename), files)
 }
 
 // Read the metadata for the index directory. Note that even if this
@@ -450,17 +1147,19 @@ func Read(dir string) (*IndexRef, error) {
 }
 
 func Build(opt *IndexOptions, dst, src, url, rev string) (*IndexRef, error) {
+	return build(opt, dst, src, url, rev, nil)
+}
+
+func build(opt *IndexOptions, dst, src, url, rev string, prev *IndexRef) (*IndexRef, error) {
 	if _, err := os.Stat(dst); err != nil {
 		if err := os.MkdirAll(dst, os.ModePerm); err != nil {
 			return nil, err
 		}
 	}
 
-	if err := os.Mkdir(filepath.Join(dst, "raw"), os.ModePerm); err != nil {
-		return nil, err
-	}
-
-	if err := indexAllFiles(opt, dst, src); err != nil {
+	// the checksums of the files that are not read again to compute them.
+	sums := map[string]string{}
+	if err := indexAllFiles(opt, dst, src, prev, sums); err != nil {
 		return nil, err
 	}
 
@@ -469,6 +1168,13 @@ func Build(opt *IndexOptions, dst, src, url, rev string) (*IndexRef, error) {
 		Rev:  rev,
 		Time: time.Now(),
 		dir:  dst,
+
+		RawFormat: rawFormatPack,
+		Options:   opt.key(),
+	}
+
+	if err := r.computeChecksums(sums); err != nil {
+		return nil, err
 	}
 
 	if err := r.writeManifest(); err != nil {
@@ -1,11 +1,16 @@
 package index
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
 	"testing"
+
+	"github.com/it-projects-llc/hound/codesearch/regexp"
 )
 
 const (
@@ -29,6 +34,33 @@ func buildIndex(url, rev string) (*IndexRef, error) {
 	return Build(&opt, dir, thisDir(), url, rev)
 }
 
+// Build and open an index of the given files, returning a function that
+// removes them both.
+func openFixture(t *testing.T, files map[string]string) (*Index, func()) {
+	src, err := ioutil.TempDir(os.TempDir(), "hound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, src, files)
+
+	ref, err := Build(&IndexOptions{}, filepath.Join(src, ".index"), src, url, rev)
+	if err != nil {
+		os.RemoveAll(src)
+		t.Fatal(err)
+	}
+
+	idx, err := ref.Open()
+	if err != nil {
+		os.RemoveAll(src)
+		t.Fatal(err)
+	}
+
+	return idx, func() {
+		idx.Close()
+		os.RemoveAll(src)
+	}
+}
+
 func TestSearch(t *testing.T) {
 	// Build an index
 	ref, err := buildIndex(url, rev)
@@ -54,9 +86,452 @@ func TestSearch(t *testing.T) {
 	defer idx.Close()
 
 	// Make sure we can carry out a search
-	if _, err := idx.Search("5a1c0dac2d9b3ea4085b30dd14375c18eab993d5", &SearchOptions{}); err != nil {
+	if _, err := idx.Search(context.Background(), "5a1c0dac2d9b3ea4085b30dd14375c18eab993d5", &SearchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchCancelled(t *testing.T) {
+	idx, done := openFixture(t, map[string]string{
+		"a.go": "needle\n",
+	})
+	defer done()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	// the pattern matches a.go, so it would be opened. Searches that run
+	// out of time return what they found rather than failing.
+	tests := []struct {
+		ctx    context.Context
+		err    error
+		reason string
+	}{
+		{cancelled, context.Canceled, ""},
+		{expired, nil, ReasonTimeLimit},
+	}
+
+	for _, test := range tests {
+		res, err := idx.Search(test.ctx, "needle", &SearchOptions{})
+		if err != test.err {
+			t.Errorf("expected %v, got %v", test.err, err)
+			continue
+		}
+
+		if err == nil && (!res.Truncated || res.Reason != test.reason || len(res.Matches) != 0) {
+			t.Errorf("expected no matches and truncation for %q, got %d %t %q",
+				test.reason, len(res.Matches), res.Truncated, res.Reason)
+		}
+	}
+}
+
+func TestSearchMatchBudget(t *testing.T) {
+	idx, done := openFixture(t, map[string]string{
+		"a.go": "func a1\nfunc a2\n",
+		"b.go": "func b1\nfunc b2\n",
+		"c.go": "func c1\n",
+	})
+	defer done()
+
+	// searches stopped by the budget only know a lower bound of the files
+	// with a match.
+	tests := []struct {
+		maxMatches int
+		matches    int
+		reason     string
+		lowerBound bool
+	}{
+		{3, 3, ReasonMatchLimit, true},
+		{10, 5, "", false},
+	}
+
+	for _, test := range tests {
+		res, err := idx.Search(context.Background(), "func", &SearchOptions{MaxMatches: test.maxMatches})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var n int
+		for _, fm := range res.Matches {
+			n += len(fm.Matches)
+		}
+
+		if n != test.matches {
+			t.Errorf("%d: expected %d matches, got %d", test.maxMatches, test.matches, n)
+		}
+
+		if res.Truncated != (test.reason != "") || res.Reason != test.reason {
+			t.Errorf("%d: expected truncation for %q, got %t %q", test.maxMatches, test.reason, res.Truncated, res.Reason)
+		}
+
+		if res.FilesWithMatchLowerBound != test.lowerBound {
+			t.Errorf("%d: expected the files with a match to be a lower bound: %t", test.maxMatches, test.lowerBound)
+		}
+
+		if !test.lowerBound && res.FilesWithMatch != 3 {
+			t.Errorf("%d: expected 3 files with a match, got %d", test.maxMatches, res.FilesWithMatch)
+		}
+	}
+}
+
+func TestSearchExcludeFiles(t *testing.T) {
+	idx, done := openFixture(t, map[string]string{
+		"a.go":      "needle\n",
+		"a_test.go": "needle\n",
+		"b.txt":     "needle\n",
+	})
+	defer done()
+
+	// excluded files are not even opened.
+	tests := []struct {
+		exclude string
+		files   []string
+	}{
+		{``, []string{"a.go", "a_test.go", "b.txt"}},
+		{`_test\.go$`, []string{"a.go", "b.txt"}},
+		{`\.(go|txt)$`, nil},
+	}
+
+	for _, test := range tests {
+		res, err := idx.Search(context.Background(), "needle", &SearchOptions{
+			ExcludeFileRegexp: test.exclude,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var files []string
+		for _, fm := range res.Matches {
+			files = append(files, fm.Filename)
+		}
+		sort.Strings(files)
+
+		if !reflect.DeepEqual(files, test.files) {
+			t.Errorf("%q: expected %v, got %v", test.exclude, test.files, files)
+		}
+
+		if res.FilesOpened != len(test.files) {
+			t.Errorf("%q: expected %d files to be opened, got %d", test.exclude, len(test.files), res.FilesOpened)
+		}
+	}
+}
+
+func TestSearchClauses(t *testing.T) {
+	idx, done := openFixture(t, map[string]string{
+		"db.go":    "sql.Open(dsn)\ndefer db.Close()\nlog.Print(dsn)\n",
+		"leak.go":  "sql.Open(dsn)\nlog.Print(dsn)\n",
+		"http.go":  "http.Get(url)\nlog.Print(url)\n",
+		"other.go": "log.Print(nothing)\n",
+	})
+	defer done()
+
+	tests := []struct {
+		clauses []Clause
+		files   []string
+	}{
+		{[]Clause{{{Pattern: `sql\.Open`}, {Pattern: `Close`, Not: true}}}, []string{"leak.go"}},
+		{[]Clause{{{Pattern: `sql\.Open`}, {Pattern: `Print`, Not: true}}}, nil},
+		{[]Clause{{{Pattern: `http\.Get`}}, {{Pattern: `sql\.Open`}}}, []string{"db.go", "http.go", "leak.go"}},
+	}
+
+	for _, test := range tests {
+		res, err := idx.SearchClauses(context.Background(), test.clauses, &SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var files []string
+		for _, fm := range res.Matches {
+			files = append(files, fm.Filename)
+			if len(fm.Matches) != 1 {
+				t.Errorf("%v: expected 1 match in %s, got %d", test.clauses, fm.Filename, len(fm.Matches))
+			}
+		}
+		sort.Strings(files)
+
+		if !reflect.DeepEqual(files, test.files) {
+			t.Errorf("%v: expected %v, got %v", test.clauses, test.files, files)
+		}
+	}
+
+	if _, err := idx.SearchClauses(context.Background(), []Clause{{{Pattern: "foo", Not: true}}}, &SearchOptions{}); err == nil {
+		t.Fatal("expected an error for a clause without a positive term")
+	}
+}
+
+func TestSearchClausesMatchSatisfiedTerms(t *testing.T) {
+	idx, done := openFixture(t, map[string]string{
+		"a.go": "alpha\nbeta\n",
+		"b.go": "beta\ngamma\n",
+	})
+	defer done()
+
+	// b.go only satisfies the second clause, so alpha is not one of its
+	// matches even though it contains beta.
+	res, err := idx.SearchClauses(context.Background(), []Clause{
+		{{Pattern: "alpha"}, {Pattern: "beta"}},
+		{{Pattern: "gamma"}},
+	}, &SearchOptions{})
+	if err != nil {
 		t.Fatal(err)
 	}
+
+	lines := map[string][]string{}
+	for _, fm := range res.Matches {
+		for _, m := range fm.Matches {
+			lines[fm.Filename] = append(lines[fm.Filename], m.Line)
+		}
+	}
+
+	expected := map[string][]string{
+		"a.go": {"alpha", "beta"},
+		"b.go": {"gamma"},
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("expected %v, got %v", expected, lines)
+	}
+}
+
+func TestSearchClausesMultiline(t *testing.T) {
+	idx, done := openFixture(t, map[string]string{
+		"a.go": "func main() {\n\treturn\n}\nlog.Print(x)\n",
+		"b.go": "func main() {\n}\nlog.Print(x)\n",
+	})
+	defer done()
+
+	// the terms span lines, which are only matched as a whole file.
+	tests := []struct {
+		clauses []Clause
+		files   []string
+	}{
+		{[]Clause{{{Pattern: `main\(\) \{\n\treturn`}, {Pattern: `log\.Print`}}}, []string{"a.go"}},
+		{[]Clause{{{Pattern: `log\.Print`}, {Pattern: `\{\n\}`, Not: true}}}, []string{"a.go"}},
+		{[]Clause{{{Pattern: `log\.Print`}, {Pattern: `\{\n\}`}}}, []string{"b.go"}},
+	}
+
+	for _, test := range tests {
+		res, err := idx.SearchClauses(context.Background(), test.clauses, &SearchOptions{Multiline: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var files []string
+		for _, fm := range res.Matches {
+			files = append(files, fm.Filename)
+		}
+		sort.Strings(files)
+
+		if !reflect.DeepEqual(files, test.files) {
+			t.Errorf("%v: expected %v, got %v", test.clauses, test.files, files)
+		}
+	}
+}
+
+func TestSearchFacets(t *testing.T) {
+	idx, done := openFixture(t, map[string]string{
+		"a.go":      "func A\nfunc B\n",
+		"cmd/b.go":  "func C\n",
+		"cmd/c.txt": "func D\nfunc E\n",
+		"README":    "nothing\n",
+	})
+	defer done()
+
+	expected := &Facets{
+		Extensions: map[string]*FacetCount{
+			"go":  {Files: 2, Lines: 3},
+			"txt": {Files: 1, Lines: 2},
+		},
+		Dirs: map[string]*FacetCount{
+			"":    {Files: 1, Lines: 2},
+			"cmd": {Files: 2, Lines: 3},
+		},
+	}
+
+	// facets count every match, however many are returned.
+	tests := []struct {
+		opt     SearchOptions
+		matches int
+	}{
+		{SearchOptions{Facets: true}, 3},
+		{SearchOptions{Facets: true, Limit: 1}, 1},
+		{SearchOptions{Facets: true, CountOnly: true, MaxMatches: 1}, 0},
+	}
+
+	for _, test := range tests {
+		res, err := idx.Search(context.Background(), "^func", &test.opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(res.Matches) != test.matches {
+			t.Errorf("%+v: expected %d files, got %d", test.opt, test.matches, len(res.Matches))
+		}
+
+		if res.FilesWithMatch != 3 || res.LinesWithMatch != 5 {
+			t.Errorf("%+v: unexpected counts: %d lines in %d files", test.opt, res.LinesWithMatch, res.FilesWithMatch)
+		}
+
+		if !reflect.DeepEqual(res.Facets, expected) {
+			t.Errorf("%+v: expected facets %v, got %v", test.opt, expected, res.Facets)
+		}
+	}
+}
+
+func TestSearchPaged(t *testing.T) {
+	idx, done := openFixture(t, map[string]string{
+		"a.go":     "needle\n",
+		"b.go":     "needle\n",
+		"c.go":     "nothing\n",
+		"d/e.go":   "needle\n",
+		"d/f.go":   "needle\n",
+		"d/g/h.go": "needle\n",
+	})
+	defer done()
+
+	all := []string{"a.go", "b.go", "d/e.go", "d/f.go", "d/g/h.go"}
+
+	// pages of any size add up to every file, in order.
+	for _, limit := range []int{1, 2, 3, 10} {
+		var paged []string
+		opt := &SearchOptions{Paged: true, Limit: limit}
+		for pages := 0; ; pages++ {
+			if pages > len(all) {
+				t.Fatalf("%d: too many pages, got %v so far", limit, paged)
+			}
+
+			res, err := idx.Search(context.Background(), "needle", opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(res.Matches) > limit {
+				t.Fatalf("%d: expected at most %d files, got %d", limit, limit, len(res.Matches))
+			}
+
+			for _, fm := range res.Matches {
+				paged = append(paged, fm.Filename)
+				opt.After = fm.Filename
+			}
+
+			if !res.HasMore {
+				break
+			}
+		}
+
+		if !reflect.DeepEqual(paged, all) {
+			t.Errorf("%d: expected %v, got %v", limit, all, paged)
+		}
+	}
+}
+
+func TestSearchPagedWithinFile(t *testing.T) {
+	idx, done := openFixture(t, map[string]string{
+		"a.go": "needle 1\nneedle 2\nneedle 3\nneedle 4\nneedle 5\n",
+		"b.go": "needle 6\nneedle 7\n",
+		"c.go": "nothing\n",
+	})
+	defer done()
+
+	// the match budget ends each page part way through a file.
+	var lines []string
+	opt := &SearchOptions{Paged: true, Limit: 10, MaxMatches: 3}
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatalf("too many pages, got %v so far", lines)
+		}
+
+		res, err := idx.Search(context.Background(), "needle", opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, fm := range res.Matches {
+			for _, m := range fm.Matches {
+				lines = append(lines, m.Line)
+			}
+		}
+
+		if !res.Truncated && !res.HasMore {
+			break
+		}
+		opt.After, opt.AfterMatches = res.After, res.AfterMatches
+	}
+
+	expected := []string{"needle 1", "needle 2", "needle 3", "needle 4", "needle 5", "needle 6", "needle 7"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("expected %v, got %v", expected, lines)
+	}
+}
+
+func TestLeadingDirs(t *testing.T) {
+	tests := map[string]string{
+		"main.go":          "",
+		"cmds/main.go":     "cmds",
+		"cmds/hound/x.go":  "cmds/hound",
+		"a/b/c/d/e/f/g.go": "a/b",
+	}
+
+	for name, dirs := range tests {
+		if got := leadingDirs(name, 2); got != dirs {
+			t.Errorf("leadingDirs(%q, 2): expected %q, got %q", name, dirs, got)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	idx, done := openFixture(t, map[string]string{
+		"a.go":  "func main() {}\n",
+		"b.go":  "var x = 1\n",
+		"c.txt": "func other() {}\n",
+	})
+	defer done()
+
+	// patterns without trigrams select every file.
+	tests := []struct {
+		pat        string
+		all        bool
+		candidates int
+		matched    int
+	}{
+		{"func main", false, 1, 1},
+		{"func", false, 2, 2},
+		{".", true, 3, 3},
+	}
+
+	for _, test := range tests {
+		ex, err := idx.Explain(context.Background(), []Clause{{{Pattern: test.pat}}}, &SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ex.Files != 3 || ex.All != test.all || ex.Candidates != test.candidates || ex.Matched != test.matched {
+			t.Errorf("%q: unexpected explanation: %+v", test.pat, ex)
+		}
+	}
+}
+
+func TestWholeWordPattern(t *testing.T) {
+	re, err := regexp.Compile(GetRegexpPatternFor("id", &SearchOptions{WholeWord: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for line, expected := range map[string]bool{
+		"id := 1":         true,
+		"return (id)":     true,
+		"x.id":            true,
+		"userid := 1":     false,
+		"user_id := 1":    false,
+		"idx := 2":        false,
+		"func f(id2 int)": false,
+	} {
+		if matched := re.MatchString(line, true, true) >= 0; matched != expected {
+			t.Errorf("expected %q to match %t, got %t", line, expected, matched)
+		}
+	}
 }
 
 func TestRemove(t *testing.T) {
@@ -0,0 +1,194 @@
+package index
+
+import (
+	"bufio"
+	"encoding/gob"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const languagesFilename = "languages.gob"
+
+// How to recognize the files of a language.
+type language struct {
+	// File extensions, without the dot.
+	Extensions []string
+
+	// Whole file names, for files like Makefile that have no extension.
+	Filenames []string
+
+	// Interpreters named on the #! line of scripts.
+	Interpreters []string
+}
+
+// The languages files are tagged with, by their lower case name.
+var languages = map[string]*language{
+	"c":          {Extensions: []string{"c", "h"}},
+	"cpp":        {Extensions: []string{"cc", "cpp", "cxx", "hh", "hpp", "hxx"}},
+	"csharp":     {Extensions: []string{"cs"}},
+	"css":        {Extensions: []string{"css", "less", "scss"}},
+	"dockerfile": {Filenames: []string{"Dockerfile"}},
+	"go":         {Extensions: []string{"go"}},
+	"html":       {Extensions: []string{"htm", "html"}},
+	"java":       {Extensions: []string{"java"}},
+	"javascript": {Extensions: []string{"js", "jsx", "mjs"}, Interpreters: []string{"node"}},
+	"json":       {Extensions: []string{"json"}},
+	"kotlin":     {Extensions: []string{"kt", "kts"}},
+	"make":       {Extensions: []string{"mk"}, Filenames: []string{"GNUmakefile", "Makefile", "makefile"}},
+	"markdown":   {Extensions: []string{"markdown", "md"}},
+	"perl":       {Extensions: []string{"pl", "pm"}, Interpreters: []string{"perl"}},
+	"php":        {Extensions: []string{"php"}, Interpreters: []string{"php"}},
+	"python":     {Extensions: []string{"py", "pyi"}, Interpreters: []string{"python", "python2", "python3"}},
+	"ruby":       {Extensions: []string{"rb"}, Filenames: []string{"Gemfile", "Rakefile"}, Interpreters: []string{"ruby"}},
+	"rust":       {Extensions: []string{"rs"}},
+	"scala":      {Extensions: []string{"scala"}},
+	"shell":      {Extensions: []string{"bash", "sh", "zsh"}, Interpreters: []string{"bash", "sh", "zsh"}},
+	"sql":        {Extensions: []string{"sql"}},
+	"swift":      {Extensions: []string{"swift"}},
+	"typescript": {Extensions: []string{"ts", "tsx"}},
+	"yaml":       {Extensions: []string{"yaml", "yml"}},
+}
+
+// Lookup tables built from languages.
+var (
+	languageByExtension   = map[string]string{}
+	languageByFilename    = map[string]string{}
+	languageByInterpreter = map[string]string{}
+)
+
+func init() {
+	for name, lang := range languages {
+		for _, ext := range lang.Extensions {
+			languageByExtension[ext] = name
+		}
+		for _, fn := range lang.Filenames {
+			languageByFilename[fn] = name
+		}
+		for _, in := range lang.Interpreters {
+			languageByInterpreter[in] = name
+		}
+	}
+}
+
+// Is name one of the languages files are tagged with?
+func IsLanguage(name string) bool {
+	return languages[strings.ToLower(name)] != nil
+}
+
+// The names of the languages files are tagged with, in sorted order.
+func Languages() []string {
+	names := make([]string, 0, len(languages))
+	for name := range languages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The language of a file from its name alone, empty if it is not known.
+func languageOfName(name string) string {
+	base := path.Base(filepath.ToSlash(name))
+	if lang, ok := languageByFilename[base]; ok {
+		return lang
+	}
+
+	if ext := path.Ext(base); ext != "" {
+		return languageByExtension[strings.ToLower(ext[1:])]
+	}
+
+	return ""
+}
+
+// The language of the script starting with the given #! line, empty if it
+// is not known. Both "#!/usr/bin/python3" and "#!/usr/bin/env python3"
+// name python3.
+func languageOfShebang(line string) string {
+	if !strings.HasPrefix(line, "#!") {
+		return ""
+	}
+
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 {
+		return ""
+	}
+
+	in := path.Base(fields[0])
+	if in == "env" {
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "-") {
+				in = f
+				break
+			}
+		}
+	}
+
+	return languageByInterpreter[in]
+}
+
+// Detect the language of a file, by its name first and then by its #! line.
+func detectLanguage(filename string) (string, error) {
+	if lang := languageOfName(filename); lang != "" {
+		return lang, nil
+	}
+
+	r, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	// a file without a newline is a single line.
+	line, _ := bufio.NewReader(r).ReadString('\n')
+
+	return languageOfShebang(strings.TrimSpace(line)), nil
+}
+
+// Write the language of each file that has one, keyed by path.
+func writeLanguages(filename string, langs map[string]string) error {
+	w, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	return gob.NewEncoder(w).Encode(langs)
+}
+
+// Read the languages of the files of an index. Indexes built before files
+// were tagged have none.
+func readLanguages(filename string) (map[string]string, error) {
+	r, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var langs map[string]string
+	if err := gob.NewDecoder(r).Decode(&langs); err != nil {
+		return nil, err
+	}
+	return langs, nil
+}
+
+// The languages of the files of the index, loaded the first time they are
+// needed.
+func (n *Index) loadLanguages() (map[string]string, error) {
+	n.langOnce.Do(func() {
+		n.langs, n.langErr = readLanguages(filepath.Join(n.Ref.dir, languagesFilename))
+	})
+	return n.langs, n.langErr
+}
+
+// The language of an indexed file given the languages of the index. Indexes
+// built before files were tagged fall back to the name of the file.
+func languageIn(langs map[string]string, name string) string {
+	if langs == nil {
+		return languageOfName(name)
+	}
+	return langs[name]
+}
@@ -0,0 +1,82 @@
+package index
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLanguageOfName(t *testing.T) {
+	tests := map[string]string{
+		"index/index.go":    "go",
+		"setup.PY":          "python",
+		"build/Makefile":    "make",
+		"Dockerfile":        "dockerfile",
+		"README":            "",
+		"scripts/bootstrap": "",
+	}
+
+	for in, out := range tests {
+		if lang := languageOfName(in); lang != out {
+			t.Errorf("languageOfName(%q): expected %q, got %q", in, out, lang)
+		}
+	}
+}
+
+func TestLanguageOfShebang(t *testing.T) {
+	tests := map[string]string{
+		"#!/bin/sh":                 "shell",
+		"#!/usr/bin/python3 -u":     "python",
+		"#!/usr/bin/env -S ruby -w": "ruby",
+		"#!/usr/bin/env node":       "javascript",
+		"#!/usr/bin/awk -f":         "",
+		"package main":              "",
+	}
+
+	for in, out := range tests {
+		if lang := languageOfShebang(in); lang != out {
+			t.Errorf("languageOfShebang(%q): expected %q, got %q", in, out, lang)
+		}
+	}
+}
+
+func TestSearchLanguages(t *testing.T) {
+	ref, err := buildIndex(url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ref.Remove()
+
+	idx, err := ref.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	res, err := idx.Search(context.Background(), "^package index", &SearchOptions{
+		Languages: []string{"go"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Matches) == 0 {
+		t.Fatal("expected matches in go files")
+	}
+
+	for _, fm := range res.Matches {
+		if fm.Language != "go" {
+			t.Fatalf("expected %s to be go, got %q", fm.Filename, fm.Language)
+		}
+	}
+
+	res, err = idx.Search(context.Background(), "^package index", &SearchOptions{
+		Languages: []string{"python"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Matches) != 0 {
+		t.Fatalf("expected no matches in python files, got %d", len(res.Matches))
+	}
+}
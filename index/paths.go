@@ -0,0 +1,139 @@
+package index
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/it-projects-llc/hound/codesearch/regexp"
+)
+
+type PathSearchOptions struct {
+	IgnoreCase bool
+	Literal    bool
+
+	// Also match the files that were left out of the index.
+	IncludeExcluded bool
+
+	// The maximum number of paths to return, zero means no limit.
+	Limit int
+}
+
+type PathMatch struct {
+	Filename string
+	Ranges   []*Range
+
+	// The reason the file was left out of the index, only set for
+	// excluded files.
+	Excluded string `json:",omitempty"`
+}
+
+type PathSearchResponse struct {
+	Matches   []*PathMatch
+	Revision  string
+	Truncated bool `json:",omitempty"`
+}
+
+// Does any of the matches fall within the base name of the file?
+func (m *PathMatch) inBasename() bool {
+	base := strings.LastIndex(m.Filename, "/") + 1
+	for _, r := range m.Ranges {
+		if r.Start >= base {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether a ranks before b. Matches within the base name rank
+// first, then shorter paths, then paths in lexical order.
+func LessPathMatch(a, b *PathMatch) bool {
+	if ab, bb := a.inBasename(), b.inBasename(); ab != bb {
+		return ab
+	}
+	if len(a.Filename) != len(b.Filename) {
+		return len(a.Filename) < len(b.Filename)
+	}
+	return a.Filename < b.Filename
+}
+
+func readExcludedFiles(filename string) ([]*ExcludedFile, error) {
+	r, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var files []*ExcludedFile
+	if err := json.NewDecoder(r).Decode(&files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Search the paths of the indexed files for the given pattern. The matches
+// are ranked by LessPathMatch.
+func (n *Index) SearchPaths(pat string, opt *PathSearchOptions) (*PathSearchResponse, error) {
+	n.lck.RLock()
+	defer n.lck.RUnlock()
+
+	re, err := regexp.Compile(GetRegexpPatternFor(pat, &SearchOptions{
+		IgnoreCase: opt.IgnoreCase,
+		Literal:    opt.Literal,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*PathMatch
+	for i, c := 0, n.idx.NumNames(); i < c; i++ {
+		name, err := n.idx.NameBytes(uint32(i))
+		if err != nil {
+			return nil, err
+		}
+
+		if ranges := rangesFor(re, name); ranges != nil {
+			matches = append(matches, &PathMatch{
+				Filename: string(name),
+				Ranges:   ranges,
+			})
+		}
+	}
+
+	if opt.IncludeExcluded {
+		files, err := readExcludedFiles(filepath.Join(n.Ref.dir, excludedFileJsonFilename))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			if ranges := rangesFor(re, []byte(file.Filename)); ranges != nil {
+				matches = append(matches, &PathMatch{
+					Filename: file.Filename,
+					Ranges:   ranges,
+					Excluded: file.Reason,
+				})
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return LessPathMatch(matches[i], matches[j])
+	})
+
+	truncated := false
+	if opt.Limit > 0 && len(matches) > opt.Limit {
+		matches = matches[:opt.Limit]
+		truncated = true
+	}
+
+	return &PathSearchResponse{
+		Matches:   matches,
+		Revision:  n.Ref.Rev,
+		Truncated: truncated,
+	}, nil
+}
@@ -0,0 +1,58 @@
+package index
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchPaths(t *testing.T) {
+	ref, err := buildIndex(url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ref.Remove()
+
+	idx, err := ref.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	res, err := idx.SearchPaths("GREP", &PathSearchOptions{IgnoreCase: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var files []string
+	for _, m := range res.Matches {
+		files = append(files, m.Filename)
+	}
+
+	if !reflect.DeepEqual(files, []string{"grep.go", "grep_test.go"}) {
+		t.Fatalf("unexpected paths: %v", files)
+	}
+
+	res, err = idx.SearchPaths(".", &PathSearchOptions{Literal: true, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Matches) != 1 || !res.Truncated {
+		t.Fatalf("expected a single truncated match, got %d", len(res.Matches))
+	}
+}
+
+func TestLessPathMatch(t *testing.T) {
+	base := &PathMatch{
+		Filename: "cmds/hound/main.go",
+		Ranges:   []*Range{{Start: 11, End: 15}},
+	}
+	dir := &PathMatch{
+		Filename: "main/x.go",
+		Ranges:   []*Range{{Start: 0, End: 4}},
+	}
+
+	if !LessPathMatch(base, dir) || LessPathMatch(dir, base) {
+		t.Fatal("expected base name matches to rank first")
+	}
+}
@@ -0,0 +1,110 @@
+package index
+
+import (
+	"math"
+	goregexp "regexp"
+	"sort"
+	"strings"
+)
+
+// Weights of the signals that go into the score of a file.
+const (
+	scoreDefinition  = 10.0
+	scoreBasename    = 5.0
+	scoreDirname     = 2.0
+	penaltyTest      = 4.0
+	penaltyVendor    = 8.0
+	penaltyGenerated = 8.0
+	penaltyDepth     = 0.5
+	penaltyLength    = 0.02
+)
+
+var (
+	testPathRegexp      = goregexp.MustCompile(`(^|/)(tests?|spec|__tests__)/|_test\.|\.(test|spec)\.|(^|/)test_`)
+	vendorPathRegexp    = goregexp.MustCompile(`(^|/)(vendor|node_modules|third_party|bower_components)/`)
+	generatedPathRegexp = goregexp.MustCompile(`\.pb\.go$|_pb2\.py$|\.min\.(js|css)$|(^|/)generated/|_generated\.|bindata\.go$`)
+
+	// Matches the start of a line declaring something, up to the name
+	// being declared.
+	definitionRegexp = goregexp.MustCompile(`^\s*(export\s+)?(pub(\(\w+\))?\s+)?(async\s+)?(static\s+)?` +
+		`(func|def|class|type|struct|interface|enum|trait|fn|impl|module|function|var|const|let)\b`)
+)
+
+// Does the match look like the definition of what was searched for?
+func isDefinition(m *Match) bool {
+	if len(m.Ranges) == 0 {
+		return false
+	}
+	return definitionRegexp.MatchString(m.Line[:m.Ranges[0].Start])
+}
+
+// Score a file by its matches and its path. Higher is better.
+func scoreFile(m matcher, fm *FileMatch) float64 {
+	name := fm.Filename
+	score := math.Log2(1 + float64(len(fm.Matches)))
+
+	for _, match := range fm.Matches {
+		if isDefinition(match) {
+			score += scoreDefinition
+			break
+		}
+	}
+
+	// prefer files whose name, rather than directory, matches.
+	base := strings.LastIndex(name, "/") + 1
+	inBase, inDir := false, false
+	for _, idx := range m.FindAllIndex([]byte(name), -1) {
+		if idx[0] >= base {
+			inBase = true
+		} else {
+			inDir = true
+		}
+	}
+
+	if inBase {
+		score += scoreBasename
+	} else if inDir {
+		score += scoreDirname
+	}
+
+	if testPathRegexp.MatchString(name) {
+		score -= penaltyTest
+	}
+	if vendorPathRegexp.MatchString(name) {
+		score -= penaltyVendor
+	}
+	if generatedPathRegexp.MatchString(name) {
+		score -= penaltyGenerated
+	}
+
+	score -= penaltyDepth * float64(strings.Count(name, "/"))
+	score -= penaltyLength * float64(len(name))
+	return score
+}
+
+// Score the files and sort them with the best first.
+func rankFiles(m matcher, files []*FileMatch) {
+	for _, fm := range files {
+		fm.Score = scoreFile(m, fm)
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].Score != files[j].Score {
+			return files[i].Score > files[j].Score
+		}
+		return files[i].Filename < files[j].Filename
+	})
+}
+
+// The files from offset up to limit of them, a zero limit means all of them.
+func pageOf(files []*FileMatch, offset, limit int) []*FileMatch {
+	if offset >= len(files) {
+		return nil
+	}
+	files = files[offset:]
+
+	if limit > 0 && limit < len(files) {
+		files = files[:limit]
+	}
+	return files
+}
@@ -0,0 +1,64 @@
+package index
+
+import (
+	"testing"
+)
+
+func matchOn(line, lit string) *Match {
+	return &Match{
+		Line:   line,
+		Ranges: rangesFor(&literalMatcher{[]byte(lit)}, []byte(line)),
+	}
+}
+
+func TestRankFiles(t *testing.T) {
+	m := &literalMatcher{[]byte("Search")}
+
+	var usages []*Match
+	for i := 0; i < 200; i++ {
+		usages = append(usages, matchOn("\tres, err := s.Search(ctx, q)", "Search"))
+	}
+
+	files := []*FileMatch{
+		{Filename: "api/api.go", Matches: usages},
+		{Filename: "vendor/x/searcher.go", Matches: []*Match{
+			matchOn("func (s *Searcher) Search() {", "Search"),
+		}},
+		{Filename: "searcher/searcher_test.go", Matches: []*Match{
+			matchOn("func TestSearch(t *testing.T) {", "Search"),
+		}},
+		{Filename: "searcher/searcher.go", Matches: []*Match{
+			matchOn("func (s *Searcher) Search(ctx context.Context) {", "Search"),
+		}},
+	}
+
+	rankFiles(m, files)
+
+	expected := []string{
+		"searcher/searcher.go",
+		"api/api.go",
+		"searcher/searcher_test.go",
+		"vendor/x/searcher.go",
+	}
+	for i, name := range expected {
+		if files[i].Filename != name {
+			t.Fatalf("expected %s at %d, got %s (%f)", name, i, files[i].Filename, files[i].Score)
+		}
+	}
+}
+
+func TestPageOf(t *testing.T) {
+	files := []*FileMatch{{Filename: "a"}, {Filename: "b"}, {Filename: "c"}}
+
+	if p := pageOf(files, 1, 1); len(p) != 1 || p[0].Filename != "b" {
+		t.Fatalf("unexpected page: %v", p)
+	}
+
+	if p := pageOf(files, 1, 0); len(p) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(p))
+	}
+
+	if p := pageOf(files, 3, 1); p != nil {
+		t.Fatalf("expected no files, got %d", len(p))
+	}
+}
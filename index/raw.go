@@ -0,0 +1,355 @@
+package index
+
+import (
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// The ways the contents of indexed files are stored, see IndexRef.RawFormat.
+const (
+	// A gzip file per indexed file under raw/, mirroring the source tree.
+	// Indexes built before pack files have this format.
+	rawFormatDir = ""
+
+	// Pack files holding the gzipped contents of many files, see packStore.
+	rawFormatPack = "pack"
+)
+
+const (
+	packTableFilename = "raw.idx"
+
+	// A new pack file is started once the current one is this large.
+	maxPackSize = 1 << 30
+)
+
+// A RawStore holds the contents of the indexed files as they were at the
+// indexed revision.
+type RawStore interface {
+	// Open the contents of an indexed file. Fails with an error satisfying
+	// os.IsNotExist when the file is not in the store.
+	Open(name string) (io.ReadCloser, error)
+
+	Close() error
+}
+
+// Open the raw store of the index in dir.
+func openRawStore(dir, format string) (RawStore, error) {
+	switch format {
+	case rawFormatDir:
+		return &dirStore{filepath.Join(dir, "raw")}, nil
+	case rawFormatPack:
+		return openPackStore(dir)
+	}
+	return nil, fmt.Errorf("unknown raw format %q", format)
+}
+
+// The raw store of older indexes, a gzip file per indexed file.
+type dirStore struct {
+	dir string
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
+func (s *dirStore) Open(name string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	// the directories of the source tree are not files of the store.
+	if fi, err := f.Stat(); err != nil {
+		f.Close()
+		return nil, err
+	} else if fi.IsDir() {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	c, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &gzipFile{c, f}, nil
+}
+
+func (s *dirStore) Close() error {
+	return nil
+}
+
+// Where the contents of a file are in the pack files.
+type packEntry struct {
+	Name string
+
+	// The pack file and the offset and length of the gzip stream holding
+	// the contents in it.
+	Pack   int
+	Offset int64
+	Length int64
+}
+
+func packFilename(dir string, pack int) string {
+	return filepath.Join(dir, fmt.Sprintf("raw-%03d.pack", pack))
+}
+
+// Packs the gzipped contents of files one after the other into a few large
+// files, along with a table of where each file is. The table is sorted by
+// name, the same order as the file ids of the trigram index.
+type packStore struct {
+	packs   []*os.File
+	entries []*packEntry
+}
+
+func openPackStore(dir string) (*packStore, error) {
+	r, err := os.Open(filepath.Join(dir, packTableFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var npacks int
+	s := &packStore{}
+	dec := gob.NewDecoder(r)
+	if err := dec.Decode(&npacks); err != nil {
+		return nil, err
+	}
+	if err := dec.Decode(&s.entries); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < npacks; i++ {
+		f, err := os.Open(packFilename(dir, i))
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.packs = append(s.packs, f)
+	}
+
+	return s, nil
+}
+
+func (s *packStore) find(name string) *packEntry {
+	i := sort.Search(len(s.entries), func(i int) bool {
+		return s.entries[i].Name >= name
+	})
+	if i < len(s.entries) && s.entries[i].Name == name {
+		return s.entries[i]
+	}
+	return nil
+}
+
+// The gzip stream of an entry.
+func (s *packStore) section(e *packEntry) *io.SectionReader {
+	return io.NewSectionReader(s.packs[e.Pack], e.Offset, e.Length)
+}
+
+func (s *packStore) Open(name string) (io.ReadCloser, error) {
+	e := s.find(name)
+	if e == nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	// reads at an offset of a shared file do not get in each other's way.
+	return gzip.NewReader(s.section(e))
+}
+
+func (s *packStore) Close() error {
+	var err error
+	for _, f := range s.packs {
+		if e := f.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Writes the pack files of an index.
+type packWriter struct {
+	dir     string
+	pack    *os.File
+	hash    hash.Hash // of what is kept of the current pack
+	npacks  int
+	off     int64
+	entries []*packEntry
+
+	// The checksums of the files written, by name, taken as they are
+	// written so that they do not have to be read again.
+	sums map[string]string
+}
+
+func createPackWriter(dir string) *packWriter {
+	return &packWriter{dir: dir, sums: map[string]string{}}
+}
+
+// Close the current pack file and record its checksum.
+func (w *packWriter) closePack() error {
+	if err := w.pack.Close(); err != nil {
+		return err
+	}
+
+	w.sums[filepath.Base(w.pack.Name())] = hex.EncodeToString(w.hash.Sum(nil))
+	w.pack = nil
+	return nil
+}
+
+// The pack file to write the next file to, a new one when there is none
+// yet or the current one is full.
+func (w *packWriter) current() (*os.File, error) {
+	if w.pack != nil && w.off < maxPackSize {
+		return w.pack, nil
+	}
+
+	if w.pack != nil {
+		if err := w.closePack(); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.Create(packFilename(w.dir, w.npacks))
+	if err != nil {
+		return nil, err
+	}
+
+	w.pack, w.hash, w.off = f, sha1.New(), 0
+	w.npacks++
+	return f, nil
+}
+
+// Counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// A file being added to a pack.
+type packFile struct {
+	*gzip.Writer
+	w    *packWriter
+	name string
+	cw   *countingWriter
+}
+
+// Start adding a file. Its contents are written to the returned file, which
+// has to be ended.
+func (w *packWriter) Begin(name string) (*packFile, error) {
+	f, err := w.current()
+	if err != nil {
+		return nil, err
+	}
+
+	cw := &countingWriter{w: f}
+	return &packFile{gzip.NewWriter(cw), w, name, cw}, nil
+}
+
+// Finish adding the file, or drop it from the pack when keep is false.
+func (p *packFile) End(keep bool) error {
+	if err := p.Writer.Close(); err != nil {
+		return err
+	}
+
+	w := p.w
+	if !keep {
+		if err := w.pack.Truncate(w.off); err != nil {
+			return err
+		}
+		_, err := w.pack.Seek(w.off, io.SeekStart)
+		return err
+	}
+
+	// the stream is only hashed once it is kept, reading it back while it
+	// is still cached.
+	if _, err := io.Copy(w.hash, io.NewSectionReader(w.pack, w.off, p.cw.n)); err != nil {
+		return err
+	}
+
+	w.entries = append(w.entries, &packEntry{
+		Name:   p.name,
+		Pack:   w.npacks - 1,
+		Offset: w.off,
+		Length: p.cw.n,
+	})
+	w.off += p.cw.n
+	return nil
+}
+
+// Copy a file from another pack store without compressing it again.
+func (w *packWriter) Copy(from *packStore, name string) error {
+	e := from.find(name)
+	if e == nil {
+		return &os.PathError{Op: "copy", Path: name, Err: os.ErrNotExist}
+	}
+
+	f, err := w.current()
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(io.MultiWriter(f, w.hash), from.section(e))
+	if err != nil {
+		return err
+	}
+
+	w.entries = append(w.entries, &packEntry{
+		Name:   name,
+		Pack:   w.npacks - 1,
+		Offset: w.off,
+		Length: n,
+	})
+	w.off += n
+	return nil
+}
+
+// Finish the last pack file and write the table.
+func (w *packWriter) Close() error {
+	if w.pack != nil {
+		if err := w.closePack(); err != nil {
+			return err
+		}
+	}
+
+	sort.Slice(w.entries, func(i, j int) bool {
+		return w.entries[i].Name < w.entries[j].Name
+	})
+
+	f, err := os.Create(filepath.Join(w.dir, packTableFilename))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha1.New()
+	enc := gob.NewEncoder(io.MultiWriter(f, h))
+	if err := enc.Encode(w.npacks); err != nil {
+		return err
+	}
+	if err := enc.Encode(w.entries); err != nil {
+		return err
+	}
+
+	w.sums[packTableFilename] = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
@@ -0,0 +1,146 @@
+package index
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func addToPack(t *testing.T, pw *packWriter, name, content string, keep bool) {
+	p, err := pw.Begin(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.Copy(p, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.End(keep); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFromStore(t *testing.T, s RawStore, name string) string {
+	r, err := s.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// The checksums taken by a pack writer are those of the files it wrote.
+func assertPackChecksums(t *testing.T, pw *packWriter) {
+	fis, err := ioutil.ReadDir(pw.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pw.sums) != len(fis) {
+		t.Fatalf("expected checksums of %d files, got %v", len(fis), pw.sums)
+	}
+
+	for _, fi := range fis {
+		h, err := hashFile(filepath.Join(pw.dir, fi.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pw.sums[fi.Name()] != h {
+			t.Fatalf("wrong checksum of %s: %s, expected %s", fi.Name(), pw.sums[fi.Name()], h)
+		}
+	}
+}
+
+func TestPackStore(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "hound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pw := createPackWriter(dir)
+	addToPack(t, pw, "b.go", "package b\n", true)
+	addToPack(t, pw, "dropped", strings.Repeat("x", 4096), false)
+	addToPack(t, pw, "a/a.go", "package a\n", true)
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assertPackChecksums(t, pw)
+
+	s, err := openPackStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if got := readFromStore(t, s, "a/a.go"); got != "package a\n" {
+		t.Fatalf("unexpected contents of a/a.go: %q", got)
+	}
+
+	if got := readFromStore(t, s, "b.go"); got != "package b\n" {
+		t.Fatalf("unexpected contents of b.go: %q", got)
+	}
+
+	if _, err := s.Open("dropped"); !os.IsNotExist(err) {
+		t.Fatalf("expected dropped to be missing, got %v", err)
+	}
+
+	// files copied to another pack keep their contents.
+	cp, err := ioutil.TempDir(os.TempDir(), "hound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cp)
+
+	pw = createPackWriter(cp)
+	if err := pw.Copy(s, "b.go"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assertPackChecksums(t, pw)
+
+	c, err := openPackStore(cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if got := readFromStore(t, c, "b.go"); got != "package b\n" {
+		t.Fatalf("unexpected contents of the copy of b.go: %q", got)
+	}
+}
+
+func TestDirStoreDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "hound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "raw", "sub"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := openRawStore(dir, rawFormatDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, name := range []string{"sub", ".", "missing"} {
+		if _, err := s.Open(name); !os.IsNotExist(err) {
+			t.Errorf("Open(%q): expected a missing file, got %v", name, err)
+		}
+	}
+}
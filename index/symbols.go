@@ -0,0 +1,382 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/it-projects-llc/hound/codesearch/regexp"
+)
+
+const symbolsFilename = "symbols.gob"
+
+// A named definition in an indexed file.
+type Symbol struct {
+	Name     string
+	Kind     string
+	Filename string
+	Line     int
+	Scope    string `json:",omitempty"`
+}
+
+// A SymbolParser extracts the symbols defined in the files it handles. Files
+// that no parser handles are left to ctags.
+type SymbolParser interface {
+	Handles(name string) bool
+	Parse(name string, src []byte) ([]*Symbol, error)
+}
+
+// The parsers used when IndexOptions.SymbolParsers is nil.
+var DefaultSymbolParsers = []SymbolParser{&GoSymbolParser{}}
+
+type SymbolSearchOptions struct {
+	IgnoreCase bool
+
+	// Only return symbols of this kind, like func or class.
+	Kind string
+
+	// The maximum number of symbols to return, zero means no limit.
+	Limit int
+}
+
+// A symbol whose name matches the pattern of a symbol search.
+type SymbolMatch struct {
+	*Symbol
+
+	// Whether the pattern matches the whole name, these rank first.
+	Exact bool `json:",omitempty"`
+}
+
+type SymbolSearchResponse struct {
+	Symbols   []*SymbolMatch
+	Revision  string
+	Truncated bool `json:",omitempty"`
+}
+
+// Parses Go files with go/parser, finding the top level functions, methods,
+// types, vars and consts.
+type GoSymbolParser struct{}
+
+func (p *GoSymbolParser) Handles(name string) bool {
+	return filepath.Ext(name) == ".go"
+}
+
+func (p *GoSymbolParser) Parse(name string, src []byte) ([]*Symbol, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, name, src, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var syms []*Symbol
+	add := func(id *ast.Ident, kind, scope string) {
+		if id == nil || id.Name == "_" {
+			return
+		}
+		syms = append(syms, &Symbol{
+			Name:     id.Name,
+			Kind:     kind,
+			Filename: name,
+			Line:     fset.Position(id.Pos()).Line,
+			Scope:    scope,
+		})
+	}
+
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil || len(d.Recv.List) == 0 {
+				add(d.Name, "func", "")
+			} else {
+				add(d.Name, "method", receiverName(d.Recv.List[0].Type))
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					add(s.Name, "type", "")
+				case *ast.ValueSpec:
+					for _, id := range s.Names {
+						add(id, d.Tok.String(), "")
+					}
+				}
+			}
+		}
+	}
+
+	return syms, nil
+}
+
+// The name of the type of a method receiver, without pointers or type
+// parameters.
+func receiverName(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// Find the symbols in the given files, relative to src, using the parsers
+// for the files they handle and ctags for the rest.
+func findSymbols(parsers []SymbolParser, src string, names []string) []*Symbol {
+	var syms []*Symbol
+	var rest []string
+	for _, name := range names {
+		var p SymbolParser
+		for _, sp := range parsers {
+			if sp.Handles(name) {
+				p = sp
+				break
+			}
+		}
+
+		if p == nil {
+			rest = append(rest, name)
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(src, name))
+		if err != nil {
+			log.Printf("symbols: %s", err)
+			continue
+		}
+
+		s, err := p.Parse(name, b)
+		if err != nil {
+			// files that do not parse simply have no symbols.
+			continue
+		}
+		syms = append(syms, s...)
+	}
+
+	return append(syms, ctagsSymbols(src, rest)...)
+}
+
+// A tag in the JSON output of universal-ctags.
+type ctagsTag struct {
+	Type  string `json:"_type"`
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Line  int    `json:"line"`
+	Kind  string `json:"kind"`
+	Scope string `json:"scope"`
+}
+
+// Run universal-ctags, if it is installed, over the given files. The
+// options files of the user and of the repo are ignored, since they could
+// change the output or have ctags run commands of the repo's choosing.
+func ctagsSymbols(src string, names []string) []*Symbol {
+	if len(names) == 0 {
+		return nil
+	}
+
+	bin, err := exec.LookPath("ctags")
+	if err != nil {
+		return nil
+	}
+
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = filepath.Join(src, name)
+	}
+
+	cmd := exec.Command(bin, "--options=NONE", "--output-format=json", "--fields=+n", "-f", "-", "-L", "-")
+	cmd.Stdin = strings.NewReader(strings.Join(paths, "\n"))
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		log.Printf("symbols: ctags failed: %s %s", err, stderr.String())
+		return nil
+	}
+
+	var syms []*Symbol
+	s := bufio.NewScanner(bytes.NewReader(out))
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var t ctagsTag
+		if err := json.Unmarshal(s.Bytes(), &t); err != nil || t.Type != "tag" {
+			continue
+		}
+
+		name, err := filepath.Rel(src, t.Path)
+		if err != nil {
+			continue
+		}
+
+		syms = append(syms, &Symbol{
+			Name:     t.Name,
+			Kind:     t.Kind,
+			Filename: filepath.ToSlash(name),
+			Line:     t.Line,
+			Scope:    t.Scope,
+		})
+	}
+
+	return syms
+}
+
+func writeSymbols(filename string, syms []*Symbol) error {
+	sort.Slice(syms, func(i, j int) bool {
+		if syms[i].Name != syms[j].Name {
+			return syms[i].Name < syms[j].Name
+		}
+		if syms[i].Filename != syms[j].Filename {
+			return syms[i].Filename < syms[j].Filename
+		}
+		return syms[i].Line < syms[j].Line
+	})
+
+	w, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	return gob.NewEncoder(w).Encode(syms)
+}
+
+// Read the symbols of an index. Indexes built without symbols have none.
+func readSymbols(filename string) ([]*Symbol, error) {
+	r, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var syms []*Symbol
+	if err := gob.NewDecoder(r).Decode(&syms); err != nil {
+		return nil, err
+	}
+	return syms, nil
+}
+
+// The symbols of the index along with the symbols of each file, loaded the
+// first time they are needed.
+func (n *Index) loadSymbols() ([]*Symbol, map[string][]*Symbol, error) {
+	n.symOnce.Do(func() {
+		n.syms, n.symErr = readSymbols(filepath.Join(n.Ref.dir, symbolsFilename))
+		n.symsByFile = map[string][]*Symbol{}
+		for _, s := range n.syms {
+			n.symsByFile[s.Filename] = append(n.symsByFile[s.Filename], s)
+		}
+	})
+	return n.syms, n.symsByFile, n.symErr
+}
+
+// Are the matched lines, the first of which is lineno, one of the
+// definitions matched by m? They are when a match covers part of the name
+// of a symbol defined on one of them, so that patterns like func Foo\( and
+// type\s+Foo find the definitions of Foo, while a match elsewhere on the
+// line of a definition does not count.
+func isDefinitionOf(m matcher, syms []*Symbol, lines [][]byte, lineno int) bool {
+	block := bytes.Join(lines, nl)
+	idxs := m.FindAllIndex(block, -1)
+
+	off := 0
+	for i, line := range lines {
+		for _, s := range syms {
+			if s.Line == lineno+i && coversName(idxs, line, off, s.Name) {
+				return true
+			}
+		}
+		off += len(line) + 1
+	}
+	return false
+}
+
+// Does any of the matches overlap an occurrence of name in the line at off
+// of the matched block?
+func coversName(idxs [][]int, line []byte, off int, name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i := 0; ; {
+		j := bytes.Index(line[i:], []byte(name))
+		if j < 0 {
+			return false
+		}
+
+		start := off + i + j
+		end := start + len(name)
+		for _, idx := range idxs {
+			if idx[0] < end && start < idx[1] {
+				return true
+			}
+		}
+		i += j + 1
+	}
+}
+
+// Search the names of the symbols in the index. Symbols whose whole name
+// matches come first, then shorter names.
+func (n *Index) SearchSymbols(pat string, opt *SymbolSearchOptions) (*SymbolSearchResponse, error) {
+	n.lck.RLock()
+	defer n.lck.RUnlock()
+
+	syms, _, err := n.loadSymbols()
+	if err != nil {
+		return nil, err
+	}
+
+	re, err := regexp.Compile(GetRegexpPattern(pat, opt.IgnoreCase))
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*SymbolMatch
+	for _, s := range syms {
+		if opt.Kind != "" && s.Kind != opt.Kind {
+			continue
+		}
+
+		idxs := re.FindAllIndex([]byte(s.Name), 1)
+		if idxs == nil {
+			continue
+		}
+
+		found = append(found, &SymbolMatch{s, idxs[0][0] == 0 && idxs[0][1] == len(s.Name)})
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].Exact != found[j].Exact {
+			return found[i].Exact
+		}
+		return len(found[i].Name) < len(found[j].Name)
+	})
+
+	res := &SymbolSearchResponse{
+		Revision: n.Ref.Rev,
+	}
+
+	if opt.Limit > 0 && len(found) > opt.Limit {
+		found = found[:opt.Limit]
+		res.Truncated = true
+	}
+
+	res.Symbols = found
+	return res, nil
+}
@@ -0,0 +1,139 @@
+package index
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/it-projects-llc/hound/codesearch/regexp"
+)
+
+func TestGoSymbolParser(t *testing.T) {
+	src := `package p
+
+type Server struct{}
+
+func (s *Server) Serve() {}
+
+func New() *Server { return nil }
+
+var (
+	_       = 1
+	Timeout = 2
+)
+`
+
+	syms, err := (&GoSymbolParser{}).Parse("p.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*Symbol{
+		{Name: "Server", Kind: "type", Filename: "p.go", Line: 3},
+		{Name: "Serve", Kind: "method", Filename: "p.go", Line: 5, Scope: "Server"},
+		{Name: "New", Kind: "func", Filename: "p.go", Line: 7},
+		{Name: "Timeout", Kind: "var", Filename: "p.go", Line: 11},
+	}
+
+	if !reflect.DeepEqual(syms, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, syms)
+	}
+}
+
+func TestSearchSymbols(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "hound")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := Build(&IndexOptions{Symbols: true}, dir, thisDir(), url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ref.Remove()
+
+	idx, err := ref.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	res, err := idx.SearchSymbols("searchsymbols", &SymbolSearchOptions{
+		IgnoreCase: true,
+		Kind:       "method",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Symbols) != 1 {
+		t.Fatalf("expected 1 symbol, got %+v", res.Symbols)
+	}
+
+	if s := res.Symbols[0]; s.Name != "SearchSymbols" || s.Filename != "symbols.go" || s.Scope != "Index" {
+		t.Fatalf("unexpected symbol: %+v", s)
+	}
+
+	// the whole name matches when the case is ignored.
+	if !res.Symbols[0].Exact {
+		t.Fatalf("expected an exact match, got %+v", res.Symbols[0])
+	}
+
+	sr, err := idx.Search(context.Background(), "readSymbols", &SearchOptions{
+		DefinitionsOnly: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sr.Matches) != 1 || len(sr.Matches[0].Matches) != 1 {
+		t.Fatalf("expected a single definition, got %+v", sr.Matches)
+	}
+
+	if m := sr.Matches[0].Matches[0]; !strings.HasPrefix(m.Line, "func readSymbols(") {
+		t.Fatalf("unexpected match: %q", m.Line)
+	}
+}
+
+func TestIsDefinitionOf(t *testing.T) {
+	syms := []*Symbol{
+		{Name: "Foo", Kind: "func", Line: 3},
+		{Name: "Bar", Kind: "type", Line: 7},
+	}
+
+	tests := []struct {
+		pat    string
+		lines  []string
+		lineno int
+		def    bool
+	}{
+		{`Foo`, []string{"func Foo(b Bar) {"}, 3, true},
+		{`func Foo\(`, []string{"func Foo(b Bar) {"}, 3, true},
+		{`(?i)foo`, []string{"func Foo(b Bar) {"}, 3, true},
+		{`type\s+Bar`, []string{"type  Bar struct {"}, 7, true},
+		{`Bar`, []string{"func Foo(b Bar) {"}, 3, false},
+		{`func`, []string{"func Foo(b Bar) {"}, 3, false},
+		{`Foo`, []string{"\tFoo()"}, 4, false},
+		{`a bar`, []string{"// Bar is a bar", "type Bar struct {"}, 6, false},
+		{`(?s)Bar is.*type Bar`, []string{"// Bar is a bar", "type Bar struct {"}, 6, true},
+	}
+
+	for _, test := range tests {
+		re, err := regexp.Compile(test.pat)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var lines [][]byte
+		for _, l := range test.lines {
+			lines = append(lines, []byte(l))
+		}
+
+		if def := isDefinitionOf(re, syms, lines, test.lineno); def != test.def {
+			t.Errorf("isDefinitionOf(%#q, %q) = %t, want %t", test.pat, test.lines, def, test.def)
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package index
+
+import (
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/it-projects-llc/hound/codesearch/index"
+)
+
+const filesFilename = "files.gob"
+
+// What an index knows about one of its files, used to reuse the file in the
+// next index of the repo when its contents have not changed.
+type fileState struct {
+	// Hex encoded SHA-1 of the contents.
+	Hash string
+
+	// Why the file is not in the index, empty if it is.
+	Excluded string
+
+	// The size and modification time of the file when it was hashed. A
+	// file that still has both is not hashed again.
+	Size    int64
+	ModTime int64
+}
+
+// Record the size and modification time of a file in its state.
+func (s *fileState) stat(info os.FileInfo) *fileState {
+	s.Size = info.Size()
+	s.ModTime = info.ModTime().UnixNano()
+	return s
+}
+
+// Whether a file still has the size and modification time it was hashed
+// with.
+func (s *fileState) unmodified(info os.FileInfo) bool {
+	return s.ModTime != 0 && s.Size == info.Size() && s.ModTime == info.ModTime().UnixNano()
+}
+
+// A key for the options that decide which files are indexed and what is
+// recorded about them, so that the files of an index are only reused by a
+// build with the same options.
+func (o *IndexOptions) key() string {
+	special := append([]string{}, o.SpecialFiles...)
+	sort.Strings(special)
+
+	var parsers []string
+	if o.Symbols {
+		ps := o.SymbolParsers
+		if ps == nil {
+			ps = DefaultSymbolParsers
+		}
+		for _, p := range ps {
+			parsers = append(parsers, fmt.Sprintf("%T", p))
+		}
+	}
+
+	return fmt.Sprintf("dot=%t special=%q symbols=%t parsers=%q",
+		o.ExcludeDotFiles, special, o.Symbols, parsers)
+}
+
+func writeFileStates(filename string, files map[string]*fileState) error {
+	w, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	return gob.NewEncoder(w).Encode(files)
+}
+
+// Read the states of the files of an index. Indexes built before files were
+// hashed have none.
+func readFileStates(filename string) (map[string]*fileState, error) {
+	r, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var files map[string]*fileState
+	if err := gob.NewDecoder(r).Decode(&files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func hashFile(filename string) (string, error) {
+	r, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	h := sha1.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Hard link dst to src, falling back to a copy when they are on different
+// devices or the file system has no hard links.
+func linkFile(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// Find the files that have not changed since prev was built and copy their
+// contents from the pack files of prev. Returns the states of the unchanged files along with
+// the names of the others, which need to be indexed. Files are only hashed
+// when their size or modification time changed.
+func reuseFiles(
+	prevFiles map[string]*fileState,
+	prevRaw *packStore,
+	pw *packWriter,
+	src string,
+	names []string) (map[string]*fileState, []string, error) {
+	files := map[string]*fileState{}
+	if prevRaw == nil {
+		return files, names, nil
+	}
+
+	var changed []string
+	for _, rel := range names {
+		ps := prevFiles[rel]
+		if ps == nil {
+			changed = append(changed, rel)
+			continue
+		}
+
+		path := filepath.Join(src, rel)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !ps.unmodified(info) {
+			hash, err := hashFile(path)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if hash != ps.Hash {
+				changed = append(changed, rel)
+				continue
+			}
+
+			// touched, but the same contents.
+			ps = (&fileState{Hash: ps.Hash, Excluded: ps.Excluded}).stat(info)
+		}
+
+		if ps.Excluded == "" {
+			if err := pw.Copy(prevRaw, rel); err != nil {
+				return nil, nil, err
+			}
+		}
+		files[rel] = ps
+	}
+
+	return files, changed, nil
+}
+
+// The paths to replace in the trigram index of prev, which are the changed
+// files and the files that are gone, along with the unchanged files that
+// must be indexed again. Merging replaces every file whose name starts with
+// one of the paths, so an unchanged foo.go is lost when foo changes unless
+// it is indexed again.
+func shadowedPaths(
+	prevFiles map[string]*fileState,
+	files map[string]*fileState,
+	changed []string) ([]string, []string) {
+	shadow := map[string]bool{}
+	for _, rel := range changed {
+		shadow[rel] = true
+	}
+	for rel, ps := range prevFiles {
+		if ps.Excluded == "" && files[rel] == nil {
+			shadow[rel] = true
+		}
+	}
+
+	paths := make([]string, 0, len(shadow))
+	for rel := range shadow {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	var readded []string
+	for rel, st := range files {
+		if st.Excluded != "" {
+			continue
+		}
+
+		for i := 1; i < len(rel); i++ {
+			if shadow[rel[:i]] {
+				readded = append(readded, rel)
+				break
+			}
+		}
+	}
+
+	return paths, readded
+}
+
+// Index a changed file, adding it to the trigram index and its contents to
+// the pack files.
+func indexFile(ix *index.IndexWriter, pw *packWriter, src, rel string) (*fileState, error) {
+	path := filepath.Join(src, rel)
+
+	// the file is stat'ed before it is read, a change while it is read
+	// leaves it with an older time and it is hashed again.
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	txt, err := isTextFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !txt {
+		hash, err := hashFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return (&fileState{Hash: hash, Excluded: reasonNotText}).stat(info), nil
+	}
+
+	h := sha1.New()
+	reason, err := addFileToIndex(ix, pw, src, path, h)
+	if err != nil {
+		return nil, err
+	}
+
+	// files that are not indexed may not have been read in full.
+	if reason != "" {
+		hash, err := hashFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return (&fileState{Hash: hash, Excluded: reason}).stat(info), nil
+	}
+
+	return (&fileState{Hash: hex.EncodeToString(h.Sum(nil))}).stat(info), nil
+}
+
+// Write a trigram index of the given files, which must be in sorted order.
+// The files that are already in files have not changed, they are only added
+// to the trigram index, and are recorded as excluded when it skips them. The
+// states of the other files are added to files.
+func indexFiles(
+	tri,
+	src string,
+	pw *packWriter,
+	paths []string,
+	files map[string]*fileState,
+	names []string) error {
+	ix := index.Create(tri)
+	defer ix.Close()
+
+	ix.AddPaths(paths)
+
+	for _, rel := range names {
+		if st := files[rel]; st != nil {
+			r, err := os.Open(filepath.Join(src, rel))
+			if err != nil {
+				return err
+			}
+			reason := ix.Add(rel, r)
+			r.Close()
+
+			if reason != "" {
+				ex := *st
+				ex.Excluded = reason
+				files[rel] = &ex
+			}
+			continue
+		}
+
+		st, err := indexFile(ix, pw, src, rel)
+		if err != nil {
+			return err
+		}
+		files[rel] = st
+	}
+
+	return ix.Flush()
+}
+
+// Merge the trigram index of the changed files into the one of prev.
+func mergeIndexes(dst, prev, delta string) error {
+	if err := index.Merge(dst, prev, delta); err != nil {
+		return fmt.Errorf("merging indexes: %v", err)
+	}
+	return nil
+}
+
+// Index the changed files into dst. Without prev these are all the files,
+// otherwise they are indexed on their own and merged with the trigram index
+// of prev. When nothing changed that index is linked and its checksum added
+// to sums.
+func indexChangedFiles(
+	dst,
+	src string,
+	pw *packWriter,
+	prev *IndexRef,
+	prevFiles map[string]*fileState,
+	files map[string]*fileState,
+	changed []string,
+	sums map[string]string) error {
+	tri := filepath.Join(dst, "tri")
+	if prev == nil {
+		return indexFiles(tri, src, pw, nil, files, changed)
+	}
+
+	paths, readded := shadowedPaths(prevFiles, files, changed)
+	if len(paths) == 0 {
+		if sum, ok := prev.Checksums["tri"]; ok {
+			sums["tri"] = sum
+		}
+		return linkFile(filepath.Join(prev.dir, "tri"), tri)
+	}
+
+	names := append(append([]string{}, changed...), readded...)
+	sort.Strings(names)
+
+	delta := filepath.Join(dst, "tri.delta")
+	defer os.Remove(delta)
+
+	if err := indexFiles(delta, src, pw, paths, files, names); err != nil {
+		return err
+	}
+
+	return mergeIndexes(tri, filepath.Join(prev.dir, "tri"), delta)
+}
+
+// The symbols of the indexed files. Those of the files that have not changed
+// since prev was built are taken from prev.
+func reuseSymbols(
+	opt *IndexOptions,
+	prev *IndexRef,
+	src string,
+	indexed []string,
+	changed []string) ([]*Symbol, error) {
+	parsers := opt.SymbolParsers
+	if parsers == nil {
+		parsers = DefaultSymbolParsers
+	}
+
+	if prev == nil {
+		return findSymbols(parsers, src, indexed), nil
+	}
+
+	// prev may have been built without symbols.
+	filename := filepath.Join(prev.dir, symbolsFilename)
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return findSymbols(parsers, src, indexed), nil
+	}
+
+	prevSyms, err := readSymbols(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	isChanged := map[string]bool{}
+	for _, rel := range changed {
+		isChanged[rel] = true
+	}
+
+	var parse []string
+	isIndexed := map[string]bool{}
+	for _, rel := range indexed {
+		isIndexed[rel] = true
+		if isChanged[rel] {
+			parse = append(parse, rel)
+		}
+	}
+
+	var syms []*Symbol
+	for _, s := range prevSyms {
+		if isIndexed[s.Filename] && !isChanged[s.Filename] {
+			syms = append(syms, s)
+		}
+	}
+
+	return append(syms, findSymbols(parsers, src, parse)...), nil
+}
+
+// Build an index of src like Build, reusing what it can of prev. The files
+// that have not changed since prev was built are copied from its pack files
+// rather than compressed again, and only the changed files are indexed and merged with
+// the trigram index of prev. Falls back to a full build when that fails.
+func Update(prev *IndexRef, opt *IndexOptions, dst, src, url, rev string) (*IndexRef, error) {
+	r, err := build(opt, dst, src, url, rev, prev)
+	if err == nil {
+		return r, nil
+	}
+
+	log.Printf("incremental build of %s failed, rebuilding: %s", url, err)
+	if err := os.RemoveAll(dst); err != nil {
+		return nil, err
+	}
+
+	return Build(opt, dst, src, url, rev)
+}
@@ -0,0 +1,305 @@
+package index
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// The names of the files matching pat.
+func filesMatching(t *testing.T, ref *IndexRef, pat string) []string {
+	idx, err := ref.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	res, err := idx.Search(context.Background(), pat, &SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, fm := range res.Matches {
+		names = append(names, fm.Filename)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestUpdate(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "hound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+
+	writeFiles(t, src, map[string]string{
+		"foo":     "needle old\n",
+		"foo.go":  "needle foo.go\n",
+		"bar.go":  "needle bar.go\n",
+		"gone.go": "needle gone.go\n",
+	})
+
+	dir, err := ioutil.TempDir(os.TempDir(), "hound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prev, err := Build(&IndexOptions{}, filepath.Join(dir, "a"), src, url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, src, map[string]string{
+		"foo":    "needle new\n",
+		"new.go": "needle new.go\n",
+	})
+	if err := os.Remove(filepath.Join(src, "gone.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := Update(prev, &IndexOptions{}, filepath.Join(dir, "b"), src, url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"bar.go", "foo", "foo.go", "new.go"}
+	if got := filesMatching(t, ref, "needle"); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	if got := filesMatching(t, ref, "old"); len(got) != 0 {
+		t.Fatalf("expected no matches for the old contents, got %v", got)
+	}
+
+	// unchanged files are copied from the pack files of prev.
+	idx, err := ref.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	res, err := idx.ReadFile("bar.go", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.Lines, []string{"needle bar.go"}) {
+		t.Fatalf("unexpected contents of bar.go: %q", res.Lines)
+	}
+}
+
+func TestUpdateUnmodifiedFiles(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "hound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+
+	writeFiles(t, src, map[string]string{
+		"foo":    "needle old\n",
+		"foo.go": "needle foo.go\n",
+		"bar.go": "needle bar.go\n",
+	})
+
+	dir, err := ioutil.TempDir(os.TempDir(), "hound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prev, err := Build(&IndexOptions{}, filepath.Join(dir, "a"), src, url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// write the files with the same size and time as before.
+	old := time.Now().Add(-time.Hour)
+	touch := func(files map[string]string) {
+		writeFiles(t, src, files)
+		for name := range files {
+			if err := os.Chtimes(filepath.Join(src, name), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// touched files are hashed and reused with their new time.
+	touch(map[string]string{
+		"foo.go": "needle foo.go\n",
+		"bar.go": "needle bar.go\n",
+	})
+
+	mid, err := Update(prev, &IndexOptions{}, filepath.Join(dir, "b"), src, url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := readFileStates(filepath.Join(mid.dir, filesFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"foo.go", "bar.go"} {
+		if files[name].ModTime != old.UnixNano() {
+			t.Fatalf("%s was not reused with its new time", name)
+		}
+	}
+
+	// the trigram index of prev is linked along with its checksum.
+	if mid.Checksums["tri"] != prev.Checksums["tri"] {
+		t.Fatalf("the checksum of the linked trigram index changed")
+	}
+	if err := mid.VerifyChecksums(); err != nil {
+		t.Fatal(err)
+	}
+
+	// files that keep their size and time are not hashed again, so their
+	// changes go unnoticed unless they are indexed again, as foo.go is when
+	// foo changes, and is skipped by the trigram index.
+	touch(map[string]string{
+		"foo.go": "needle \xff\xffo.go\n",
+		"bar.go": "needle BAR.go\n",
+	})
+	writeFiles(t, src, map[string]string{"foo": "needle new\n"})
+
+	ref, err := Update(mid, &IndexOptions{}, filepath.Join(dir, "c"), src, url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"bar.go", "foo"}
+	if got := filesMatching(t, ref, "needle"); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	files, err = readFileStates(filepath.Join(ref.dir, filesFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files["foo.go"].Excluded == "" {
+		t.Fatalf("foo.go was skipped without being excluded")
+	}
+	if files["bar.go"].Excluded != "" {
+		t.Fatalf("bar.go was excluded: %s", files["bar.go"].Excluded)
+	}
+
+	if err := ref.VerifyChecksums(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateNonASCIINames(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "hound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+
+	writeFiles(t, src, map[string]string{
+		"é":     "needle old\n",
+		"é.go":  "needle é.go\n",
+		"ê.go":  "needle ê.go\n",
+		"z.go":  "needle z.go\n",
+		"\x7f1": "needle 7f\n",
+		"\x802": "needle 80\n",
+	})
+
+	dir, err := ioutil.TempDir(os.TempDir(), "hound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prev, err := Build(&IndexOptions{}, filepath.Join(dir, "a"), src, url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, src, map[string]string{
+		"é":     "needle new\n",
+		"\x7f1": "needle 7f new\n",
+	})
+
+	ref, err := Update(prev, &IndexOptions{}, filepath.Join(dir, "b"), src, url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"z.go", "\x7f1", "\x802", "é", "é.go", "ê.go"}
+	if got := filesMatching(t, ref, "needle"); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+
+	if got := filesMatching(t, ref, "old"); len(got) != 0 {
+		t.Fatalf("expected no matches for the old contents, got %q", got)
+	}
+}
+
+// Finds a symbol named after each file.
+type fileSymbolParser struct{}
+
+func (p *fileSymbolParser) Handles(name string) bool {
+	return true
+}
+
+func (p *fileSymbolParser) Parse(name string, src []byte) ([]*Symbol, error) {
+	return []*Symbol{{Name: name, Kind: "file", Filename: name, Line: 1}}, nil
+}
+
+func TestUpdateWithOtherOptions(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "hound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+
+	writeFiles(t, src, map[string]string{
+		"a.go": "package a\n\nfunc Needle() {}\n",
+	})
+
+	dir, err := ioutil.TempDir(os.TempDir(), "hound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prev, err := Build(&IndexOptions{Symbols: true}, filepath.Join(dir, "a"), src, url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opt := &IndexOptions{
+		Symbols:       true,
+		SymbolParsers: []SymbolParser{&fileSymbolParser{}},
+	}
+	ref, err := Update(prev, opt, filepath.Join(dir, "b"), src, url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	syms, err := readSymbols(filepath.Join(ref.Dir(), symbolsFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(syms) != 1 || syms[0].Kind != "file" {
+		t.Fatalf("expected the symbols of the new parser, got %v", syms)
+	}
+}
@@ -0,0 +1,115 @@
+package index
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/it-projects-llc/hound/codesearch/index"
+)
+
+// The size and modification time of a file of an index, which Verify checks
+// instead of its checksum.
+type fileStamp struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Record the checksum, size and modification time of every file of the
+// index, all of which are written by now but the manifest. The files whose
+// checksums are in sums, taken while the pack files were written or of the
+// files linked from the previous index, are not read again.
+func (r *IndexRef) computeChecksums(sums map[string]string) error {
+	fis, err := ioutil.ReadDir(r.dir)
+	if err != nil {
+		return err
+	}
+
+	r.Checksums = map[string]string{}
+	r.Stamps = map[string]fileStamp{}
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() || fi.Name() == manifestFilename {
+			continue
+		}
+
+		h, ok := sums[fi.Name()]
+		if !ok {
+			var err error
+			if h, err = hashFile(filepath.Join(r.dir, fi.Name())); err != nil {
+				return err
+			}
+		}
+		r.Checksums[fi.Name()] = h
+		r.Stamps[fi.Name()] = fileStamp{fi.Size(), fi.ModTime()}
+	}
+
+	return nil
+}
+
+func verifyChecksum(filename, want string) error {
+	h, err := hashFile(filename)
+	if err != nil {
+		return err
+	}
+
+	if h != want {
+		return fmt.Errorf("%s: checksum mismatch", filename)
+	}
+	return nil
+}
+
+// Check that the files of the index are intact and that its trigram index
+// and raw store open. This is cheap enough to do for every index at startup:
+// besides the trigram index, which every search reads, only the files whose
+// size or modification time changed since the index was built are hashed
+// and compared with their checksums, VerifyChecksums checks them all. Indexes built before checksums were recorded only have
+// the trigram index and raw store checked, those built before sizes were
+// recorded have every file hashed.
+func (r *IndexRef) Verify() error {
+	for name, want := range r.Checksums {
+		filename := filepath.Join(r.dir, name)
+		if st, ok := r.Stamps[name]; ok && name != "tri" {
+			fi, err := os.Stat(filename)
+			if err != nil {
+				return err
+			}
+
+			if fi.Size() != st.Size {
+				return fmt.Errorf("%s: size mismatch", filename)
+			}
+
+			if fi.ModTime().Equal(st.ModTime) {
+				continue
+			}
+		}
+
+		if err := verifyChecksum(filename, want); err != nil {
+			return err
+		}
+	}
+
+	ix, err := index.Open(filepath.Join(r.dir, "tri"))
+	if err != nil {
+		return err
+	}
+	ix.Close()
+
+	raw, err := openRawStore(r.dir, r.RawFormat)
+	if err != nil {
+		return err
+	}
+	return raw.Close()
+}
+
+// Check that every file of the index still has the checksum recorded when
+// it was built.
+func (r *IndexRef) VerifyChecksums() error {
+	for name, want := range r.Checksums {
+		if err := verifyChecksum(filepath.Join(r.dir, name), want); err != nil {
+			return err
+		}
+	}
+	return nil
+}
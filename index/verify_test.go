@@ -0,0 +1,132 @@
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Overwrite part of a file of an index.
+func damageFile(t *testing.T, filename string, truncate bool) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if truncate {
+		b = b[:len(b)/2]
+	} else {
+		b[len(b)/2] ^= 0xff
+	}
+
+	if err := ioutil.WriteFile(filename, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ref, err := buildIndex(url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ref.Remove()
+
+	if len(ref.Checksums) == 0 || ref.Checksums["tri"] == "" {
+		t.Fatalf("no checksums recorded: %v", ref.Checksums)
+	}
+
+	r, err := Read(ref.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Verify(); err != nil {
+		t.Fatalf("verifying an intact index: %s", err)
+	}
+
+	damageFile(t, packFilename(ref.Dir(), 0), false)
+	if err := r.Verify(); err == nil {
+		t.Error("a damaged pack file passed verification")
+	}
+	if err := r.VerifyChecksums(); err == nil {
+		t.Error("a damaged pack file passed checksum verification")
+	}
+}
+
+func TestVerifyUnchangedStamps(t *testing.T) {
+	ref, err := buildIndex(url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ref.Remove()
+
+	// a file damaged in place, keeping its size and modification time, is
+	// only caught by its checksum.
+	filename := packFilename(ref.Dir(), 0)
+	st := ref.Stamps[filepath.Base(filename)]
+	damageFile(t, filename, false)
+	if err := os.Chtimes(filename, st.ModTime, st.ModTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ref.Verify(); err != nil {
+		t.Fatalf("expected only the sizes and modification times to be checked, got %s", err)
+	}
+
+	if err := ref.VerifyChecksums(); err == nil {
+		t.Error("a damaged pack file passed checksum verification")
+	}
+
+	// the trigram index is always hashed.
+	filename = filepath.Join(ref.Dir(), "tri")
+	st = ref.Stamps["tri"]
+	damageFile(t, filename, false)
+	if err := os.Chtimes(filename, st.ModTime, st.ModTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ref.Verify(); err == nil {
+		t.Error("a damaged trigram index passed verification")
+	}
+}
+
+func TestVerifyTruncatedTrigramIndex(t *testing.T) {
+	ref, err := buildIndex(url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ref.Remove()
+
+	damageFile(t, filepath.Join(ref.Dir(), "tri"), true)
+
+	if err := ref.Verify(); err == nil {
+		t.Error("a truncated trigram index passed verification")
+	}
+
+	// indexes built before checksums were recorded are still opened.
+	ref.Checksums = nil
+	if err := ref.Verify(); err == nil {
+		t.Error("a truncated trigram index passed verification without checksums")
+	}
+
+	if _, err := ref.Open(); err == nil {
+		t.Error("a truncated trigram index was opened")
+	}
+}
+
+func TestVerifyMissingFile(t *testing.T) {
+	ref, err := buildIndex(url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ref.Remove()
+
+	if err := os.Remove(filepath.Join(ref.Dir(), packTableFilename)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ref.Verify(); err == nil {
+		t.Error("an index missing its pack table passed verification")
+	}
+}
@@ -0,0 +1,385 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/it-projects-llc/hound/codesearch/index"
+	"github.com/it-projects-llc/hound/codesearch/regexp"
+)
+
+// Compile the clauses into the matcher used to find the matching lines,
+// along with the compiled terms and the trigram query. Only searches made
+// of more than a single pattern need each file checked against the compiled
+// terms before grepping it.
+func searchMatcher(clauses []Clause, opt *SearchOptions) (matcher, [][]*compiledTerm, *index.Query, error) {
+	compiled, q, err := compileClauses(clauses, opt)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if !isBoolean(clauses) {
+		return matcherFor(clauses[0][0].Pattern, compiled[0][0].re, opt), compiled, q, nil
+	}
+
+	re, err := regexp.Compile(GetRegexpPatternForClauses(clauses, opt))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return re, compiled, q, nil
+}
+
+func isBoolean(clauses []Clause) bool {
+	return len(clauses) > 1 || len(clauses[0]) > 1
+}
+
+// What grepping a candidate file found.
+type fileGrep struct {
+	name     string
+	lang     string
+	hasMatch bool
+
+	// Were the contents of the file in the cache of the index?
+	cached bool
+
+	// Did grepping stop at the first match, since the file was thought to
+	// be below the offset of the search?
+	stopped bool
+
+	// The number of matching lines. It is only complete for counting
+	// searches, the others stop grepping once they have what they need.
+	matched int
+
+	// The matches found until no more were needed.
+	matches []*Match
+
+	err error
+}
+
+// Greps candidate files for a search. Each worker of a search has its own
+// copy, since neither the buffer of a grepper nor a compiled regexp can be
+// shared between goroutines.
+type grepWorker struct {
+	g          grepper
+	m          matcher
+	compiled   [][]*compiledTerm
+	boolean    bool
+	clauses    []Clause
+	raw        RawStore
+	files      *cachedStore
+	opt        *SearchOptions
+	symsByFile map[string][]*Symbol
+	maxMatches int
+	counting   bool
+
+	// Set once no more matches will be collected, after which whether a
+	// file matches is all that counts.
+	full *int32
+
+	// Set while the files being grepped may be below the offset of the
+	// search, whose matches are not collected either.
+	skipping *int32
+
+	// The matchers for the terms of only some of the clauses, keyed by
+	// the indexes of those clauses.
+	partial map[string]matcher
+}
+
+// Compile the matchers of the worker when it gets its first file, so that
+// the workers left without files compile nothing.
+func (w *grepWorker) compile() error {
+	if w.m != nil {
+		return nil
+	}
+
+	m, compiled, _, err := searchMatcher(w.clauses, w.opt)
+	if err != nil {
+		return err
+	}
+
+	w.m, w.compiled = m, compiled
+	return nil
+}
+
+// The matcher for the terms of the given clauses, which is m when they are
+// all of them.
+func (w *grepWorker) matcherFor(satisfied []int) (matcher, error) {
+	if len(satisfied) == len(w.clauses) {
+		return w.m, nil
+	}
+
+	key := fmt.Sprint(satisfied)
+	if m, ok := w.partial[key]; ok {
+		return m, nil
+	}
+
+	clauses := make([]Clause, len(satisfied))
+	for i, c := range satisfied {
+		clauses[i] = w.clauses[c]
+	}
+
+	m, err := regexp.Compile(GetRegexpPatternForClauses(clauses, w.opt))
+	if err != nil {
+		return nil, err
+	}
+
+	if w.partial == nil {
+		w.partial = map[string]matcher{}
+	}
+	w.partial[key] = m
+	return m, nil
+}
+
+func (w *grepWorker) grep(ctx context.Context, f *fileGrep) {
+	if err := w.compile(); err != nil {
+		f.err = err
+		return
+	}
+
+	if w.files != nil {
+		hits := w.files.hits
+		defer func() {
+			f.cached = w.files.hits > hits
+		}()
+	}
+
+	// cached files are grepped in place, along with where their lines
+	// start, others are read into the buffer of the grepper.
+	var buf []byte
+	var lines []int
+	if w.files != nil {
+		cf, err := w.files.file(f.name)
+		if err != nil {
+			f.err = err
+			return
+		}
+		buf, lines = cf.data, cf.lines
+	} else {
+		var err error
+		if buf, err = w.g.readFile(w.raw, f.name); err != nil {
+			f.err = err
+			return
+		}
+	}
+
+	// only the terms of the clauses that the file satisfies are matched.
+	m := w.m
+	if w.boolean {
+		satisfied, err := satisfiedClauses(ctx, buf, w.compiled, w.opt.Multiline)
+		if err != nil || len(satisfied) == 0 {
+			f.err = err
+			return
+		}
+
+		if m, err = w.matcherFor(satisfied); err != nil {
+			f.err = err
+			return
+		}
+	}
+
+	// the matches of a file that an earlier page already returned.
+	skip := 0
+	if w.opt.AfterMatches > 0 && f.name == w.opt.After {
+		skip = w.opt.AfterMatches
+	}
+
+	collect := func(lines [][]byte, lineno int, before [][]byte, after [][]byte) (bool, error) {
+		if w.opt.DefinitionsOnly && !isDefinitionOf(m, w.symsByFile[f.name], lines, lineno) {
+			return true, nil
+		}
+
+		if skip > 0 {
+			skip--
+			return true, nil
+		}
+
+		f.hasMatch = true
+		f.matched++
+		if !w.counting && atomic.LoadInt32(w.skipping) != 0 {
+			f.stopped = true
+			return false, nil
+		}
+
+		if atomic.LoadInt32(w.full) != 0 || len(f.matches) >= w.maxMatches {
+			return w.counting, nil
+		}
+
+		f.matches = append(f.matches, newMatch(m, lines, lineno, before, after))
+		return true, nil
+	}
+
+	// counting searches return no lines, so they need no context either.
+	nctx := int(w.opt.LinesOfContext)
+	if w.opt.CountOnly {
+		nctx = 0
+	}
+
+	if w.opt.Multiline {
+		f.err = grepBlocksBuf(ctx, buf, lines, m, nctx, collect)
+	} else {
+		f.err = grep2Buf(ctx, buf, lines, m, nctx,
+			func(line []byte, lineno int, before [][]byte, after [][]byte) (bool, error) {
+				return collect([][]byte{line}, lineno, before, after)
+			})
+	}
+}
+
+type grepJob struct {
+	f    *fileGrep
+	done chan *fileGrep
+}
+
+// Candidate files being grepped by a pool of workers. The results come out
+// of next in the order of the candidates, whatever order the workers finish
+// them in.
+type grepPipeline struct {
+	order  chan *grepJob
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Greps the files that turn out not to be below the offset in full,
+	// see regrep.
+	w *grepWorker
+}
+
+// Start grepping the accepted candidates with opt.Workers copies of w, or
+// one per candidate when there are fewer.
+func (n *Index) startGrep(
+	ctx context.Context,
+	clauses []Clause,
+	opt *SearchOptions,
+	files []uint32,
+	accept func(name string) (string, bool),
+	w *grepWorker) *grepPipeline {
+	workers := opt.Workers
+	if workers > len(files) {
+		workers = len(files)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	var pool []*grepWorker
+	for i := 0; i <= workers; i++ {
+		c := *w
+		c.boolean, c.clauses = isBoolean(clauses), clauses
+		if n.cache != nil {
+			c.files = &cachedStore{raw: n.raw, cache: n.cache}
+			c.raw = c.files
+		}
+		pool = append(pool, &c)
+	}
+
+	// the last copy is never below the offset.
+	last := pool[workers]
+	last.skipping = new(int32)
+	pool = pool[:workers]
+
+	ctx, cancel := context.WithCancel(ctx)
+	p := &grepPipeline{
+		// the workers run at most this far ahead of the results.
+		order:  make(chan *grepJob, 2*workers),
+		cancel: cancel,
+		w:      last,
+	}
+
+	jobs := make(chan *grepJob)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(jobs)
+		defer close(p.order)
+
+		for _, file := range files {
+			name, err := n.idx.Name(file)
+			if err != nil {
+				// the search fails once it gets to the file.
+				j := &grepJob{done: make(chan *fileGrep, 1)}
+				j.done <- &fileGrep{err: err}
+				select {
+				case p.order <- j:
+				case <-ctx.Done():
+				}
+				return
+			}
+
+			lang, ok := accept(name)
+			if !ok {
+				continue
+			}
+
+			j := &grepJob{
+				f:    &fileGrep{name: name, lang: lang},
+				done: make(chan *fileGrep, 1),
+			}
+
+			select {
+			case p.order <- j:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case jobs <- j:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for _, w := range pool {
+		p.wg.Add(1)
+		go func(w *grepWorker) {
+			defer p.wg.Done()
+			for j := range jobs {
+				// stop opening files once the search has been abandoned.
+				if err := ctx.Err(); err != nil {
+					j.f.err = err
+				} else {
+					w.grep(ctx, j.f)
+				}
+				j.done <- j.f
+			}
+		}(w)
+	}
+
+	return p
+}
+
+// The next grepped file, nil once there are no more. Fails with the error
+// of ctx once it is done.
+func (p *grepPipeline) next(ctx context.Context) (*fileGrep, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	j, ok := <-p.order
+	if !ok {
+		return nil, ctx.Err()
+	}
+
+	select {
+	case f := <-j.done:
+		return f, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Grep a file that stopped at its first match again, in full. The workers
+// may stop too early on the files just past the offset, since they only
+// learn where it is once the results before them are in.
+func (p *grepPipeline) regrep(ctx context.Context, f *fileGrep) (*fileGrep, error) {
+	g := &fileGrep{name: f.name, lang: f.lang}
+	p.w.grep(ctx, g)
+	return g, g.err
+}
+
+// Stop grepping, waiting for the files being grepped to be done.
+func (p *grepPipeline) stop() {
+	p.cancel()
+	p.wg.Wait()
+}
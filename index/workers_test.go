@@ -0,0 +1,86 @@
+package index
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestSearchWorkers(t *testing.T) {
+	ref, err := buildIndex(url, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ref.Remove()
+
+	idx, err := ref.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	opts := []SearchOptions{
+		{},
+		{Offset: 2, Limit: 3},
+		{MaxMatches: 7},
+		{Facets: true, Limit: 2},
+		{CountOnly: true},
+		{Paged: true, Limit: 2},
+		{Rank: true, Limit: 4},
+	}
+
+	for _, opt := range opts {
+		one := opt
+		many := opt
+		many.Workers = 8
+
+		a, err := idx.Search(context.Background(), "func", &one)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := idx.Search(context.Background(), "func", &many)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a.Duration, b.Duration = 0, 0
+		a.FilesOpened, b.FilesOpened = 0, 0
+		if !reflect.DeepEqual(a, b) {
+			t.Errorf("%+v: results differ with more workers", opt)
+		}
+	}
+}
+
+func TestSearchWorkersPastOffset(t *testing.T) {
+	files := map[string]string{}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		files[name] = "needle 1\nneedle 2\nneedle 3\n"
+	}
+
+	idx, cleanup := openFixture(t, files)
+	defer cleanup()
+
+	for _, workers := range []int{1, 2, 8} {
+		res, err := idx.Search(context.Background(), "needle", &SearchOptions{
+			Offset:  3,
+			Limit:   2,
+			Workers: workers,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, fm := range res.Matches {
+			got = append(got, fm.Filename)
+			if len(fm.Matches) != 3 {
+				t.Errorf("%d workers: expected every match of %s, got %d", workers, fm.Filename, len(fm.Matches))
+			}
+		}
+
+		if !reflect.DeepEqual(got, []string{"d", "e"}) {
+			t.Errorf("%d workers: expected d and e, got %v", workers, got)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+// Package query parses the search syntax shared by the UI, the API and the
+// command line client. A query is a pattern mixed with operators that
+// narrow down where and how the pattern is searched for:
+//
+//	repo:payments file:\.go$ -file:_test case:yes http.Client
+//
+// The supported operators are repo:, -repo:, file:, -file:, lang: and case:.
+// Values containing spaces can be double quoted, as can the pattern itself.
+// Anything that is not an operator is part of the pattern.
+//
+// Several patterns can be combined with AND, OR and NOT to find the files
+// satisfying all of them, any of them or none of them:
+//
+//	sql.Open AND NOT "defer .*Close"
+//
+// AND binds tighter than OR and a NOT that directly follows a pattern is
+// the same as AND NOT. Quoting the keywords makes them part of the pattern.
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/it-projects-llc/hound/index"
+)
+
+// Values accepted by the case: operator.
+const (
+	caseYes  = "yes"
+	caseNo   = "no"
+	caseAuto = "auto"
+)
+
+// Error describes a problem with a query along with the position, in bytes,
+// where it was found.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+// A parsed query.
+type Query struct {
+	// The pattern to search for. Empty when several patterns are combined
+	// with AND, OR or NOT.
+	Pattern string
+
+	// The patterns in disjunctive normal form: a file is a match when it
+	// satisfies any one of the clauses. A query without AND, OR and NOT has
+	// a single clause holding Pattern.
+	Clauses []index.Clause
+
+	// Regexps on the names of the repos to search and to skip.
+	Repos        []string
+	ExcludeRepos []string
+
+	// Regexps on the paths of the files to search and to skip.
+	Files        []string
+	ExcludeFiles []string
+
+	// Names of the languages to search, see lang:.
+	Languages []string
+
+	// The value of case:, empty when the operator is absent.
+	Case string
+}
+
+type token struct {
+	pos  int
+	end  int
+	neg  bool
+	key  string // empty for parts of the pattern
+	text string
+}
+
+// The keywords combining patterns. They are kept as the keys of their
+// tokens, in lower case.
+var keywords = map[string]bool{
+	"AND": true,
+	"OR":  true,
+	"NOT": true,
+}
+
+var operators = map[string]bool{
+	"repo": true,
+	"file": true,
+	"lang": true,
+	"case": true,
+}
+
+// Operators that may be negated with a leading '-'.
+var negatable = map[string]bool{
+	"repo": true,
+	"file": true,
+}
+
+// Read a double quoted string starting at s[pos]. Backslash escaped quotes
+// and backslashes are unescaped, all other backslashes are kept so regexp
+// escapes survive quoting.
+func readQuoted(s string, pos int) (string, int, error) {
+	var b strings.Builder
+	for i := pos + 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+				i++
+				b.WriteByte(s[i])
+				continue
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, &Error{pos, "unterminated quote"}
+}
+
+// Read a run of non-space characters starting at s[pos].
+func readWord(s string, pos int) (string, int) {
+	i := pos
+	for i < len(s) {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if unicode.IsSpace(r) {
+			break
+		}
+		i += n
+	}
+	return s[pos:i], i
+}
+
+// If s[pos:] starts with an operator, return its key, whether it is negated
+// and the offset of its value.
+func readOperator(s string, pos int) (string, bool, int) {
+	i := pos
+	neg := false
+	if i < len(s) && s[i] == '-' {
+		neg = true
+		i++
+	}
+
+	c := strings.IndexByte(s[i:], ':')
+	if c < 0 {
+		return "", false, 0
+	}
+
+	key := s[i : i+c]
+	if !operators[key] || (neg && !negatable[key]) {
+		return "", false, 0
+	}
+
+	return key, neg, i + c + 1
+}
+
+func tokenize(s string) ([]*token, error) {
+	var toks []*token
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if unicode.IsSpace(r) {
+			i += n
+			continue
+		}
+
+		t := &token{pos: i}
+		if key, neg, vpos := readOperator(s, i); key != "" {
+			t.key, t.neg = key, neg
+			i = vpos
+		}
+
+		var err error
+		if i < len(s) && s[i] == '"' {
+			t.text, i, err = readQuoted(s, i)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			t.text, i = readWord(s, i)
+			if t.key == "" && keywords[t.text] {
+				t.key = strings.ToLower(t.text)
+			}
+		}
+
+		if t.key != "" && t.text == "" {
+			return nil, &Error{t.pos, fmt.Sprintf("missing value for %s:", t.key)}
+		}
+
+		t.end = i
+		toks = append(toks, t)
+	}
+	return toks, nil
+}
+
+// Ensure that the value of an operator is a valid regexp.
+func checkRegexp(t *token) error {
+	if _, err := syntax.Parse(t.text, syntax.Perl); err != nil {
+		return &Error{t.pos, fmt.Sprintf("invalid regexp for %s: %s", t.key, err)}
+	}
+	return nil
+}
+
+// A query of just the pattern s, taken as is without looking for operators.
+func Raw(s string) *Query {
+	return &Query{
+		Pattern: s,
+		Clauses: []index.Clause{{{Pattern: s}}},
+	}
+}
+
+// Parse a query.
+func Parse(s string) (*Query, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Query{}
+
+	var (
+		pat         strings.Builder
+		clause      index.Clause
+		not         bool
+		prev        *token
+		keyword     *token
+		clausePos   int
+		hasKeywords bool
+	)
+
+	// end the pattern being read as a term of the current clause.
+	endTerm := func(t *token) error {
+		if pat.Len() == 0 {
+			return &Error{t.pos, fmt.Sprintf("missing pattern before %s", t.text)}
+		}
+		clause = append(clause, index.Term{Pattern: pat.String(), Not: not})
+		pat.Reset()
+		not = false
+		return nil
+	}
+
+	// end the current clause, it needs a term that is not negated.
+	endClause := func() error {
+		for _, t := range clause {
+			if !t.Not {
+				q.Clauses = append(q.Clauses, clause)
+				clause = nil
+				return nil
+			}
+		}
+		return &Error{clausePos, "patterns combined with AND need one without NOT"}
+	}
+
+	for _, t := range toks {
+		if clausePos < 0 {
+			clausePos = t.pos
+		}
+
+		switch t.key {
+		case "":
+			// Parts of the pattern keep the spacing between them unless they
+			// were separated by operators.
+			if prev != nil && prev.key == "" {
+				pat.WriteString(s[prev.end:t.pos])
+			} else if pat.Len() > 0 {
+				pat.WriteByte(' ')
+			}
+			pat.WriteString(t.text)
+		case "and", "or":
+			if err := endTerm(t); err != nil {
+				return nil, err
+			}
+			if t.key == "or" {
+				if err := endClause(); err != nil {
+					return nil, err
+				}
+				clausePos = -1
+			}
+			keyword, hasKeywords = t, true
+		case "not":
+			if not {
+				return nil, &Error{t.pos, "NOT cannot follow NOT"}
+			}
+			if pat.Len() > 0 {
+				if err := endTerm(t); err != nil {
+					return nil, err
+				}
+			}
+			not = true
+			keyword, hasKeywords = t, true
+		case "repo":
+			if err := checkRegexp(t); err != nil {
+				return nil, err
+			}
+			if t.neg {
+				q.ExcludeRepos = append(q.ExcludeRepos, t.text)
+			} else {
+				q.Repos = append(q.Repos, t.text)
+			}
+		case "file":
+			if err := checkRegexp(t); err != nil {
+				return nil, err
+			}
+			if t.neg {
+				q.ExcludeFiles = append(q.ExcludeFiles, t.text)
+			} else {
+				q.Files = append(q.Files, t.text)
+			}
+		case "lang":
+			name := strings.ToLower(t.text)
+			if !index.IsLanguage(name) {
+				return nil, &Error{t.pos, fmt.Sprintf("unknown language %q", t.text)}
+			}
+			q.Languages = append(q.Languages, name)
+		case "case":
+			switch c := strings.ToLower(t.text); c {
+			case caseYes, caseNo, caseAuto:
+				q.Case = c
+			default:
+				return nil, &Error{t.pos, fmt.Sprintf("case: must be yes, no or auto, not %q", t.text)}
+			}
+		}
+		prev = t
+	}
+
+	if pat.Len() == 0 && hasKeywords {
+		return nil, &Error{keyword.pos, fmt.Sprintf("missing pattern after %s", keyword.text)}
+	}
+
+	clause = append(clause, index.Term{Pattern: pat.String(), Not: not})
+	if err := endClause(); err != nil {
+		return nil, err
+	}
+
+	if !hasKeywords {
+		q.Pattern = q.Clauses[0][0].Pattern
+	}
+
+	return q, nil
+}
+
+// Combine several regexps into one that matches whenever any of them does.
+func anyOf(res []string) string {
+	if len(res) == 1 {
+		return res[0]
+	}
+
+	alts := make([]string, len(res))
+	for i, re := range res {
+		alts[i] = "(?:" + re + ")"
+	}
+	return strings.Join(alts, "|")
+}
+
+// Does any of the patterns contain upper case letters?
+func hasUpper(clauses []index.Clause) bool {
+	for _, clause := range clauses {
+		for _, t := range clause {
+			for _, r := range t.Pattern {
+				if unicode.IsUpper(r) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Apply the operators of the query to the search options. Options that the
+// query does not mention are left alone.
+func (q *Query) Apply(opt *index.SearchOptions) {
+	if len(q.Files) > 0 {
+		opt.FileRegexp = anyOf(q.Files)
+	}
+
+	if len(q.Languages) > 0 {
+		opt.Languages = q.Languages
+	}
+
+	if len(q.ExcludeFiles) > 0 {
+		opt.ExcludeFileRegexp = anyOf(q.ExcludeFiles)
+	}
+
+	switch q.Case {
+	case caseYes:
+		opt.IgnoreCase = false
+	case caseNo:
+		opt.IgnoreCase = true
+	case caseAuto:
+		opt.IgnoreCase = !hasUpper(q.Clauses)
+	}
+}
+
+// Filter the given repo names down to those selected by the query.
+func (q *Query) FilterRepos(repos []string) []string {
+	if len(q.Repos) == 0 && len(q.ExcludeRepos) == 0 {
+		return repos
+	}
+
+	var inc, exc *regexp.Regexp
+	if len(q.Repos) > 0 {
+		inc = regexp.MustCompile(anyOf(q.Repos))
+	}
+	if len(q.ExcludeRepos) > 0 {
+		exc = regexp.MustCompile(anyOf(q.ExcludeRepos))
+	}
+
+	var res []string
+	for _, repo := range repos {
+		if inc != nil && !inc.MatchString(repo) {
+			continue
+		}
+		if exc != nil && exc.MatchString(repo) {
+			continue
+		}
+		res = append(res, repo)
+	}
+	return res
+}
@@ -0,0 +1,160 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/it-projects-llc/hound/index"
+)
+
+func single(pat string) []index.Clause {
+	return []index.Clause{{{Pattern: pat}}}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in  string
+		out *Query
+	}{
+		{"http.Client", &Query{
+			Pattern: "http.Client",
+			Clauses: single("http.Client"),
+		}},
+		{"repo:payments file:\\.go$ -file:_test case:yes http.Client", &Query{
+			Pattern:      "http.Client",
+			Clauses:      single("http.Client"),
+			Repos:        []string{"payments"},
+			Files:        []string{`\.go$`},
+			ExcludeFiles: []string{"_test"},
+			Case:         "yes",
+		}},
+		{"foo  bar file:x baz", &Query{
+			Pattern: "foo  bar baz",
+			Clauses: single("foo  bar baz"),
+			Files:   []string{"x"},
+		}},
+		{`"file:not an operator" -repo:"old stuff" lang:Go`, &Query{
+			Pattern:      "file:not an operator",
+			Clauses:      single("file:not an operator"),
+			ExcludeRepos: []string{"old stuff"},
+			Languages:    []string{"go"},
+		}},
+		{`"say \"hi\"\.$"`, &Query{
+			Pattern: `say "hi"\.$`,
+			Clauses: single(`say "hi"\.$`),
+		}},
+		{"http://example.com -lang:go", &Query{
+			Pattern: "http://example.com -lang:go",
+			Clauses: single("http://example.com -lang:go"),
+		}},
+	}
+
+	for _, test := range tests {
+		q, err := Parse(test.in)
+		if err != nil {
+			t.Errorf("Parse(%q): %s", test.in, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(q, test.out) {
+			t.Errorf("Parse(%q): expected %+v, got %+v", test.in, test.out, q)
+		}
+	}
+}
+
+func TestParseBoolean(t *testing.T) {
+	tests := []struct {
+		in  string
+		out []index.Clause
+	}{
+		{"sql.Open AND NOT defer .*Close", []index.Clause{
+			{{Pattern: "sql.Open"}, {Pattern: "defer .*Close", Not: true}},
+		}},
+		{"a AND b OR c NOT d file:x", []index.Clause{
+			{{Pattern: "a"}, {Pattern: "b"}},
+			{{Pattern: "c"}, {Pattern: "d", Not: true}},
+		}},
+		{`"AND" OR "x OR y"`, []index.Clause{
+			{{Pattern: "AND"}},
+			{{Pattern: "x OR y"}},
+		}},
+	}
+
+	for _, test := range tests {
+		q, err := Parse(test.in)
+		if err != nil {
+			t.Errorf("Parse(%q): %s", test.in, err)
+			continue
+		}
+
+		if q.Pattern != "" || !reflect.DeepEqual(q.Clauses, test.out) {
+			t.Errorf("Parse(%q): expected %+v, got %q %+v", test.in, test.out, q.Pattern, q.Clauses)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		in  string
+		pos int
+	}{
+		{`foo "bar`, 4},
+		{"foo file:", 4},
+		{"foo file:(", 4},
+		{"case:maybe foo", 0},
+		{"foo lang:klingon", 4},
+		{"AND foo", 0},
+		{"foo OR", 4},
+		{"foo NOT NOT bar", 8},
+		{"foo OR NOT bar", 7},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.in)
+		qe, ok := err.(*Error)
+		if !ok {
+			t.Errorf("Parse(%q): expected an error, got %v", test.in, err)
+			continue
+		}
+
+		if qe.Pos != test.pos {
+			t.Errorf("Parse(%q): expected error at %d, got %d (%s)", test.in, test.pos, qe.Pos, qe)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	q, err := Parse("file:a file:b -file:c case:no foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var opt index.SearchOptions
+	q.Apply(&opt)
+	if opt.FileRegexp != "(?:a)|(?:b)" || opt.ExcludeFileRegexp != "c" || !opt.IgnoreCase {
+		t.Fatalf("unexpected options: %+v", opt)
+	}
+
+	q, err = Parse("lang:python file:src case:auto Foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opt = index.SearchOptions{IgnoreCase: true}
+	q.Apply(&opt)
+	if !reflect.DeepEqual(opt.Languages, []string{"python"}) || opt.FileRegexp != "src" || opt.IgnoreCase {
+		t.Fatalf("unexpected options: %+v", opt)
+	}
+}
+
+func TestFilterRepos(t *testing.T) {
+	q, err := Parse("repo:pay -repo:legacy foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := q.FilterRepos([]string{"payments", "payments-legacy", "search"})
+	if !reflect.DeepEqual(repos, []string{"payments"}) {
+		t.Fatalf("unexpected repos: %v", repos)
+	}
+}
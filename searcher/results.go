@@ -0,0 +1,144 @@
+package searcher
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+
+	"github.com/it-projects-llc/hound/index"
+)
+
+// The bytes a cached response is charged for on top of its strings for
+// each file and match in it, roughly what their structs and slices take.
+const cachedMatchOverhead = 128
+
+// The most recent search responses of a searcher, keyed by the revision of
+// the index they were found in along with the search itself, up to a total
+// number of bytes. It is safe for concurrent use.
+type resultCache struct {
+	lck     sync.Mutex
+	max     int64
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type cachedResult struct {
+	key  string
+	res  *index.SearchResponse
+	size int64
+}
+
+// An estimate of the bytes the response to the search with the given key
+// takes in memory.
+func resultSize(key string, res *index.SearchResponse) int64 {
+	n := int64(len(key)) + cachedMatchOverhead
+	for _, fm := range res.Matches {
+		n += int64(len(fm.Filename)+len(fm.Language)) + cachedMatchOverhead
+		for _, m := range fm.Matches {
+			n += int64(len(m.Line)+32*len(m.Ranges)) + cachedMatchOverhead
+			for _, lines := range [][]string{m.Before, m.After, m.Lines} {
+				for _, l := range lines {
+					n += int64(len(l)) + 16
+				}
+			}
+		}
+	}
+
+	if f := res.Facets; f != nil {
+		for _, counts := range []map[string]*index.FacetCount{f.Extensions, f.Dirs} {
+			for k := range counts {
+				n += int64(len(k)) + cachedMatchOverhead
+			}
+		}
+	}
+	return n
+}
+
+func newResultCache(max int64) *resultCache {
+	return &resultCache{
+		max:     max,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// The key of a search of the given revision. Options that do not change
+// the response of a search that is not cut short by its time limit are
+// left out, so that a search differing only by those shares the response.
+func resultKey(rev string, clauses []index.Clause, opt *index.SearchOptions) (string, error) {
+	o := *opt
+	o.Workers = 0
+	o.Timeout = 0
+
+	b, err := json.Marshal(&struct {
+		Rev     string
+		Clauses []index.Clause
+		Opt     *index.SearchOptions
+	}{rev, clauses, &o})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Can the response be served again to the same search? Searches cut short
+// by their time limit could find more the next time.
+func isCacheable(res *index.SearchResponse) bool {
+	return res.Reason != index.ReasonTimeLimit
+}
+
+// A copy of the cached response for the key, marked as cached, or nil if
+// there is none.
+func (c *resultCache) get(key string) *index.SearchResponse {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(e)
+
+	// the response was found without opening any files this time.
+	res := *e.Value.(*cachedResult).res
+	res.FilesOpened = 0
+	res.FilesCached = 0
+	res.Cached = true
+	return &res
+}
+
+func (c *resultCache) add(key string, res *index.SearchResponse) {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+
+	// responses that would not fit even in an empty cache are never cached.
+	size := resultSize(key, res)
+	if size > c.max {
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&cachedResult{key, res, size})
+	c.size += size
+
+	for c.size > c.max {
+		old := c.lru.Remove(c.lru.Back()).(*cachedResult)
+		delete(c.entries, old.key)
+		c.size -= old.size
+	}
+}
+
+// Drop every cached response.
+func (c *resultCache) clear() {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+
+	c.lru.Init()
+	c.entries = map[string]*list.Element{}
+	c.size = 0
+}
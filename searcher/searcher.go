@@ -1,6 +1,7 @@
 package searcher
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
@@ -23,6 +24,10 @@ type Searcher struct {
 	lck  sync.RWMutex
 	Repo *config.Repo
 
+	// The responses to recent searches of idx, nil when they are not
+	// cached.
+	results *resultCache
+
 	// The channel is used to request updates from the API and
 	// to signal that it is ok for searchers to begin polling.
 	// It has a buffer size of 1 to allow at most one pending
@@ -114,17 +119,81 @@ func (s *Searcher) swapIndexes(idx *index.Index) error {
 	oldIdx := s.idx
 	s.idx = idx
 
+	if s.results != nil {
+		s.results.clear()
+	}
+
+	// destroying the old index drops the contents of its files cached in
+	// memory, the new one starts with an empty cache.
 	return oldIdx.Destroy()
 }
 
 // Perform a basic search on the current index using the supplied pattern
-// and the options.
+// and the options. The search is abandoned when ctx is done.
 //
 // TODO(knorton): pat should really just be a part of SearchOptions
-func (s *Searcher) Search(pat string, opt *index.SearchOptions) (*index.SearchResponse, error) {
+func (s *Searcher) Search(ctx context.Context, pat string, opt *index.SearchOptions) (*index.SearchResponse, error) {
+	return s.SearchClauses(ctx, []index.Clause{{{Pattern: pat}}}, opt)
+}
+
+// Search the paths of the files in the current index, see
+// index.SearchPaths.
+func (s *Searcher) SearchPaths(pat string, opt *index.PathSearchOptions) (*index.PathSearchResponse, error) {
+	s.lck.RLock()
+	defer s.lck.RUnlock()
+	return s.idx.SearchPaths(pat, opt)
+}
+
+// Read lines of a file in the current index, see index.ReadFile.
+func (s *Searcher) ReadFile(path string, from, to int) (*index.FileResponse, error) {
 	s.lck.RLock()
 	defer s.lck.RUnlock()
-	return s.idx.Search(pat, opt)
+	return s.idx.ReadFile(path, from, to)
+}
+
+// Search the symbols of the current index, see index.SearchSymbols.
+func (s *Searcher) SearchSymbols(pat string, opt *index.SymbolSearchOptions) (*index.SymbolSearchResponse, error) {
+	s.lck.RLock()
+	defer s.lck.RUnlock()
+	return s.idx.SearchSymbols(pat, opt)
+}
+
+// Explain a search of the current index, see index.Explain.
+func (s *Searcher) Explain(ctx context.Context, clauses []index.Clause, opt *index.SearchOptions) (*index.Explanation, error) {
+	s.lck.RLock()
+	defer s.lck.RUnlock()
+	return s.idx.Explain(ctx, clauses, opt)
+}
+
+// Search the current index for files satisfying any of the clauses, see
+// index.SearchClauses. The same search of the same revision is answered
+// from the result cache, with the response marked as Cached.
+func (s *Searcher) SearchClauses(ctx context.Context, clauses []index.Clause, opt *index.SearchOptions) (*index.SearchResponse, error) {
+	s.lck.RLock()
+	defer s.lck.RUnlock()
+
+	if s.results == nil {
+		return s.idx.SearchClauses(ctx, clauses, opt)
+	}
+
+	key, err := resultKey(s.idx.Ref.Rev, clauses, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	if res := s.results.get(key); res != nil {
+		return res, nil
+	}
+
+	res, err := s.idx.SearchClauses(ctx, clauses, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	if isCacheable(res) {
+		s.results.add(key, res)
+	}
+	return res, nil
 }
 
 // Get the excluded files as a JSON string. This is only used for returning
@@ -208,9 +277,14 @@ func findExistingRefs(dbpath string) (*foundRefs, error) {
 		return nil, err
 	}
 
+	// dirs without a readable manifest are kept as refs that no repo
+	// claims, so that they are removed along with the other unclaimed ones.
 	var refs []*index.IndexRef
 	for _, dir := range dirs {
-		r, _ := index.Read(dir)
+		r, err := index.Read(dir)
+		if err != nil {
+			log.Printf("unreadable index %s will be removed: %s", dir, err)
+		}
 		refs = append(refs, r)
 	}
 
@@ -242,6 +316,23 @@ func buildAndOpenIndex(
 	return index.Open(idxDir)
 }
 
+// Build a new index in idxDir that reuses the unchanged files of the
+// previous index of the repo, see index.Update.
+func updateAndOpenIndex(
+	prev *index.IndexRef,
+	opt *index.IndexOptions,
+	vcsDir,
+	idxDir,
+	url,
+	rev string) (*index.Index, error) {
+	r, err := index.Update(prev, opt, idxDir, vcsDir, url, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Open()
+}
+
 // Simply prints out statistics about the heap. When hound rebuilds a new
 // index it will expand the heap with a decent amount of garbage. This is
 // helpful to ensure the heap growth looks sane.
@@ -359,10 +450,14 @@ func updateAndReindex(
 		return rev, false
 	}
 
+	s.lck.RLock()
+	prev := s.idx.Ref
+	s.lck.RUnlock()
+
 	log.Printf("Rebuilding %s for %s", name, newRev)
-	idx, err := buildAndOpenIndex(
+	idx, err := updateAndOpenIndex(
+		prev,
 		opt,
-		dbpath,
 		vcsDir,
 		nextIndexDir(dbpath),
 		repo.Url,
@@ -371,6 +466,7 @@ func updateAndReindex(
 		log.Printf("failed index build (%s): %s", name, err)
 		return rev, false
 	}
+	idx.SetFileCacheSize(repo.FileCacheBytes())
 
 	if err := s.swapIndexes(idx); err != nil {
 		log.Printf("failed index swap (%s): %s", name, err)
@@ -383,6 +479,51 @@ func updateAndReindex(
 	return newRev, true
 }
 
+// Check the checksums of every file of the index the searcher started
+// with, replacing it with a new build when they do not match.
+func verifyOrRebuild(
+	s *Searcher,
+	ref *index.IndexRef,
+	dbpath,
+	vcsDir,
+	name string,
+	opt *index.IndexOptions,
+	lim limiter) {
+
+	// hashing the files of an index reads as much as building it.
+	lim.Acquire()
+	defer lim.Release()
+
+	err := ref.VerifyChecksums()
+	if err == nil {
+		return
+	}
+
+	log.Printf("index %s of %s failed verification, rebuilding: %s", ref.Dir(), name, err)
+	r, err := index.Build(opt, nextIndexDir(dbpath), vcsDir, ref.Url, ref.Rev)
+	if err != nil {
+		log.Printf("failed index build (%s): %s", name, err)
+		return
+	}
+
+	idx, err := r.Open()
+	if err != nil {
+		log.Printf("failed index build (%s): %s", name, err)
+		if err := r.Remove(); err != nil {
+			log.Printf("failed to remove index (%s): %s\n", name, err)
+		}
+		return
+	}
+	idx.SetFileCacheSize(s.Repo.FileCacheBytes())
+
+	if err := s.swapIndexes(idx); err != nil {
+		log.Printf("failed index swap (%s): %s", name, err)
+		if err := idx.Destroy(); err != nil {
+			log.Printf("failed to destroy index (%s): %s\n", name, err)
+		}
+	}
+}
+
 // Creates a new Searcher that is capable of re-claiming an existing index directory
 // from a set of existing manifests.
 func newSearcher(
@@ -403,6 +544,7 @@ func newSearcher(
 	opt := &index.IndexOptions{
 		ExcludeDotFiles: repo.ExcludeDotFiles,
 		SpecialFiles:    wd.SpecialFiles(),
+		Symbols:         repo.IndexSymbols,
 	}
 
 	rev, err := wd.PullOrClone(vcsDir, repo.Url)
@@ -410,8 +552,17 @@ func newSearcher(
 		return nil, err
 	}
 
+	// an existing index that fails verification is left unclaimed, to be
+	// removed once every searcher has started, and built again.
 	var idxDir string
 	ref := refs.find(repo.Url, rev)
+	if ref != nil {
+		if err := ref.Verify(); err != nil {
+			log.Printf("index %s of %s failed verification, rebuilding: %s", ref.Dir(), name, err)
+			ref = nil
+		}
+	}
+
 	if ref == nil {
 		idxDir = nextIndexDir(dbpath)
 	} else {
@@ -429,6 +580,7 @@ func newSearcher(
 	if err != nil {
 		return nil, err
 	}
+	idx.SetFileCacheSize(repo.FileCacheBytes())
 
 	s := &Searcher{
 		idx:        idx,
@@ -438,11 +590,22 @@ func newSearcher(
 		shutdownCh: make(chan empty, 1),
 	}
 
+	if max := repo.ResultCacheBytes(); max > 0 {
+		s.results = newResultCache(max)
+	}
+
 	go func() {
 
 		// each searcher's poller is held until begin is called.
 		<-s.updateCh
 
+		// a claimed index only had the sizes and modification times of its
+		// files checked, its checksums are checked once every searcher has
+		// started.
+		if ref != nil {
+			verifyOrRebuild(s, ref, dbpath, vcsDir, name, opt, lim)
+		}
+
 		// if all forms of updating are turned off, we're done here.
 		if !repo.PollUpdatesEnabled() && !repo.PushUpdatesEnabled() {
 			s.completeShutdown()
@@ -78,7 +78,11 @@ var ParamsFromUrl = function(params) {
   params = params || {
     q: '',
     i: 'nope',
+    literal: 'nope',
+    word: 'nope',
+    rank: 'nope',
     files: '',
+    excludeFiles: '',
     repos: '*'
   };
   return ParamsFromQueryString(location.search, params);
@@ -89,6 +93,78 @@ var ParamValueToBool = function(v) {
   return v == 'fosho' || v == 'true' || v == '1';
 };
 
+/**
+ * Escape a string so that it only matches itself when used in a RegExp.
+ */
+var EscapeRegExp = function(v) {
+  return v.replace(/[.*+?^${}()|[\]\\]/g, '\\$&');
+};
+
+/**
+ * Strip the operators (repo:, file:, lang:, case:) from a query and return
+ * the patterns it combines with AND and OR, leaving out those negated with
+ * NOT. The server does the real parsing, this is just enough to highlight
+ * the patterns in the results.
+ */
+var QueryPatterns = function(q) {
+  q = q.replace(/(^|\s)(-?(repo|file)|lang|case):("(\\.|[^"\\])*"|\S+)/g, '$1').trim();
+
+  var patterns = [];
+  q.split(/\s+(?:AND|OR)\s+/).forEach(function(part) {
+    part.split(/\s+NOT\s+/).forEach(function(term, i) {
+      if (i > 0 || /^NOT\s/.test(term)) {
+        return;
+      }
+      patterns.push(term.replace(/^"(.*)"$/, '$1'));
+    });
+  });
+  return patterns;
+};
+
+/**
+ * The score of the best file in the results for a repo.
+ */
+var TopScore = function(result) {
+  return result.Matches.length > 0 ? (result.Matches[0].Score || 0) : 0;
+};
+
+/**
+ * Read the newline-delimited JSON frames of a streamed response, calling
+ * onFrame with each as soon as it arrives. Reading stops early once onFrame
+ * returns false.
+ */
+var ReadStream = function(url, onFrame, onError) {
+  fetch(url).then(function(resp) {
+    if (!resp.ok) {
+      throw new Error(resp.statusText);
+    }
+
+    var reader = resp.body.getReader(),
+        decoder = new TextDecoder(),
+        buf = '';
+
+    var pump = function() {
+      return reader.read().then(function(chunk) {
+        if (chunk.done) {
+          return;
+        }
+
+        buf += decoder.decode(chunk.value, {stream: true});
+        var lines = buf.split('\n');
+        buf = lines.pop();
+        for (var i = 0; i < lines.length; i++) {
+          if (lines[i] !== '' && !onFrame(JSON.parse(lines[i]))) {
+            reader.cancel();
+            return;
+          }
+        }
+        return pump();
+      });
+    };
+    return pump();
+  }).catch(onError);
+};
+
 /**
  * The data model for the UI is responsible for conducting searches and managing
  * all results.
@@ -180,61 +256,77 @@ var Model = {
     // to produce an error, we simply return empty results
     // immediately in the client.
     if (params.q == '') {
+      _this.searching = null;
       _this.results = [];
       _this.resultsByRepo = {};
       _this.didSearch.raise(_this, _this.Results);
       return;
     }
 
-    $.ajax({
-      url: 'api/v1/search',
-      data: params,
-      type: 'GET',
-      dataType: 'json',
-      success: function(data) {
-        if (data.Error) {
-          _this.didError.raise(_this, data.Error);
-          return;
+    // frames of a search that has been superseded are dropped.
+    var search = _this.searching = {},
+        results = [];
+
+    // show the results so far, the stats are only known once every repo
+    // has been searched.
+    var show = function(stats) {
+      // ranked files come sorted best first, so the repos are sorted by
+      // their first file.
+      var ranked = ParamValueToBool(params.rank);
+      results.sort(function(a, b) {
+        if (ranked) {
+          return TopScore(b) - TopScore(a) || a.Repo.localeCompare(b.Repo);
         }
+        return b.Matches.length - a.Matches.length || a.Repo.localeCompare(b.Repo);
+      });
 
-        var matches = data.Results,
-            stats = data.Stats,
-            results = [];
-        for (var repo in matches) {
-          if (!matches[repo]) {
-            continue;
-          }
+      var byRepo = {};
+      results.forEach(function(res) {
+        byRepo[res.Repo] = res;
+      });
 
-          var res = matches[repo];
-          results.push({
-            Repo: repo,
-            Rev: res.Revision,
-            Matches: res.Matches,
-            FilesWithMatch: res.FilesWithMatch,
-          });
-        }
+      _this.results = results;
+      _this.resultsByRepo = byRepo;
+      _this.stats = stats;
 
-        results.sort(function(a, b) {
-          return b.Matches.length - a.Matches.length || a.Repo.localeCompare(b.Repo);
-        });
+      _this.didSearch.raise(_this, _this.results, _this.stats);
+    };
 
-        var byRepo = {};
-        results.forEach(function(res) {
-          byRepo[res.Repo] = res;
-        });
+    ReadStream('api/v1/search/stream?' + $.param(params), function(frame) {
+      if (_this.searching !== search) {
+        return false;
+      }
+
+      if (frame.Error) {
+        _this.didError.raise(_this, frame.Error);
+        return false;
+      }
 
-        _this.results = results;
-        _this.resultsByRepo = byRepo;
-        _this.stats = {
-          Server: stats.Duration,
+      if (frame.Stats) {
+        show({
+          Server: frame.Stats.Duration,
           Total: Date.now() - startedAt,
-          Files: stats.FilesOpened
-        };
+          Files: frame.Stats.FilesOpened
+        });
+        return true;
+      }
 
-        _this.didSearch.raise(_this, _this.results, _this.stats);
-      },
-      error: function(xhr, status, err) {
-        _this.didError.raise(this, "The server broke down");
+      var res = frame.Result;
+      if (!res) {
+        return true;
+      }
+
+      results.push({
+        Repo: frame.Repo,
+        Rev: res.Revision,
+        Matches: res.Matches,
+        FilesWithMatch: res.FilesWithMatch,
+      });
+      show(null);
+      return true;
+    }, function(err) {
+      if (_this.searching === search) {
+        _this.didError.raise(_this, "The server broke down");
       }
     });
   },
@@ -299,6 +391,11 @@ var Model = {
 
   UrlToRepo: function(repo, path, line, rev) {
     return UrlToRepo(this.repos[repo], path, line, rev);
+  },
+
+  // The indexed copy of a file, served by hound itself.
+  UrlToFile: function(repo, path) {
+    return 'api/v1/file?' + $.param({repo: repo, path: path, format: 'raw'});
   }
 
 };
@@ -376,12 +473,28 @@ var SearchBar = createReactClass({
   filesGotFocus: function(event) {
     this.showAdvanced();
   },
+  excludeFilesGotKeydown: function(event) {
+    if (event.keyCode == 13) {
+      this.submitQuery();
+    }
+  },
   submitQuery: function() {
     this.props.onSearchRequested(this.getParams());
   },
   getRegExp : function() {
+    var literal = this.refs.literal.checked,
+        word = this.refs.word.checked;
+    var q = QueryPatterns(this.refs.q.value.trim()).map(function(p) {
+      if (literal) {
+        p = EscapeRegExp(p);
+      }
+      if (word) {
+        p = '\\b(?:' + p + ')\\b';
+      }
+      return '(?:' + p + ')';
+    }).join('|');
     return new RegExp(
-      this.refs.q.value.trim(),
+      q,
       this.refs.icase.checked ? 'ig' : 'g');
   },
   getParams: function() {
@@ -398,22 +511,35 @@ var SearchBar = createReactClass({
 
     return {
       q : this.refs.q.value.trim(),
+      syntax : 'query',
       files : this.refs.files.value.trim(),
+      excludeFiles : this.refs.excludeFiles.value.trim(),
       repos : repos.join(','),
-      i: this.refs.icase.checked ? 'fosho' : 'nope'
+      i: this.refs.icase.checked ? 'fosho' : 'nope',
+      literal: this.refs.literal.checked ? 'fosho' : 'nope',
+      word: this.refs.word.checked ? 'fosho' : 'nope',
+      rank: this.refs.rank.checked ? 'fosho' : 'nope'
     };
   },
   setParams: function(params) {
     var q = this.refs.q,
         i = this.refs.icase,
-        files = this.refs.files;
+        literal = this.refs.literal,
+        word = this.refs.word,
+        rank = this.refs.rank,
+        files = this.refs.files,
+        excludeFiles = this.refs.excludeFiles;
 
     q.value = params.q;
     i.checked = ParamValueToBool(params.i);
+    literal.checked = ParamValueToBool(params.literal);
+    word.checked = ParamValueToBool(params.word);
+    rank.checked = ParamValueToBool(params.rank);
     files.value = params.files;
+    excludeFiles.value = params.excludeFiles;
   },
   hasAdvancedValues: function() {
-    return this.refs.files.value.trim() !== '' || this.refs.icase.checked || this.refs.repos.value !== '';
+    return this.refs.files.value.trim() !== '' || this.refs.excludeFiles.value.trim() !== '' || this.refs.icase.checked || this.refs.literal.checked || this.refs.word.checked || this.refs.rank.checked || this.refs.repos.value !== '';
   },
   showAdvanced: function() {
     var adv = this.refs.adv,
@@ -506,12 +632,40 @@ var SearchBar = createReactClass({
                     onFocus={this.filesGotFocus} />
               </div>
             </div>
+            <div className="field">
+              <label htmlFor="exclude-files">Exclude Files</label>
+              <div className="field-input">
+                <input type="text"
+                    id="exclude-files"
+                    placeholder="regexp"
+                    ref="excludeFiles"
+                    onKeyDown={this.excludeFilesGotKeydown} />
+              </div>
+            </div>
             <div className="field">
               <label htmlFor="ignore-case">Ignore Case</label>
               <div className="field-input">
                 <input id="ignore-case" type="checkbox" ref="icase" />
               </div>
             </div>
+            <div className="field">
+              <label htmlFor="literal">Literal</label>
+              <div className="field-input">
+                <input id="literal" type="checkbox" ref="literal" />
+              </div>
+            </div>
+            <div className="field">
+              <label htmlFor="word">Whole Word</label>
+              <div className="field-input">
+                <input id="word" type="checkbox" ref="word" />
+              </div>
+            </div>
+            <div className="field">
+              <label htmlFor="rank">Rank Files</label>
+              <div className="field-input">
+                <input id="rank" type="checkbox" ref="rank" />
+              </div>
+            </div>
             <div className="field">
               <label className="multiselect_label" htmlFor="repos">Select Repo</label>
               <div className="field-input">
@@ -522,7 +676,7 @@ var SearchBar = createReactClass({
             </div>
           </div>
           <div className="ban" ref="ban" onClick={this.showAdvanced}>
-            <em>Advanced:</em> ignore case, filter by path, stuff like that.
+            <em>Advanced:</em> ignore case, literal and whole word search, filter by path, stuff like that.
           </div>
         </div>
         {statsView}
@@ -547,12 +701,19 @@ var MatchToLines = function(match) {
     });
   });
 
-  lines.push({
-    Number: base,
-    Content: match.Line,
-    Match: true
+  // matches spanning several lines carry all of their lines.
+  var matched = match.Lines || [match.Line];
+  matched.forEach(function(line, index) {
+    lines.push({
+      Number: base + index,
+      Content: line,
+      Ranges: matched.length == 1 ? match.Ranges : null,
+      Match: true
+    });
   });
 
+  base += matched.length - 1;
+
   match.After.forEach(function(line, index) {
     lines.push({
       Number: base + index + 1,
@@ -590,7 +751,9 @@ var CoalesceMatches = function(matches) {
         } else if (current && line.Match) {
           // we have to go back into current and make sure that matches
           // are properly marked.
-          current[current.length - 1 - (max - line.Number)].Match = true;
+          var prev = current[current.length - 1 - (max - line.Number)];
+          prev.Match = true;
+          prev.Ranges = line.Ranges;
         }
       });
     } else {
@@ -618,6 +781,26 @@ var EscapeHtml = function(text) {
 };
 EscapeHtml.e = document.createElement('div');
 
+/**
+ * Produce html for a line using the ranges reported by the server to highlight
+ * matches.
+ */
+var ContentForRanges = function(line) {
+  // ranges are given in runes, so split the line into code points.
+  var chars = Array.from(line.Content),
+      buffer = [],
+      beg = 0;
+
+  line.Ranges.forEach(function(range) {
+    buffer.push(EscapeHtml(chars.slice(beg, range.RuneStart).join('')));
+    buffer.push('<em>' + EscapeHtml(chars.slice(range.RuneStart, range.RuneEnd).join('')) + '</em>');
+    beg = range.RuneEnd;
+  });
+
+  buffer.push(EscapeHtml(chars.slice(beg).join('')));
+  return buffer.join('');
+};
+
 /**
  * Produce html for a line using the regexp to highlight matches.
  */
@@ -625,6 +808,11 @@ var ContentFor = function(line, regexp) {
   if (!line.Match) {
     return EscapeHtml(line.Content);
   }
+
+  if (line.Ranges) {
+    return ContentForRanges(line);
+  }
+
   var content = line.Content,
       buffer = [];
 
@@ -681,6 +869,7 @@ var FilesView = createReactClass({
             <a href={Model.UrlToRepo(repo, match.Filename, null, rev)}>
               {match.Filename}
             </a>
+            <a className="raw" href={Model.UrlToFile(repo, match.Filename)} target="_blank">raw</a>
           </div>
           <div className="file-body">
             {matches}
@@ -769,7 +958,11 @@ var App = createReactClass({
     this.setState({
       q: params.q,
       i: params.i,
+      literal: params.literal,
+      word: params.word,
+      rank: params.rank,
       files: params.files,
+      excludeFiles: params.excludeFiles,
       repos: repos
     });
 
@@ -823,7 +1016,11 @@ var App = createReactClass({
     var path = location.pathname +
       '?q=' + encodeURIComponent(params.q) +
       '&i=' + encodeURIComponent(params.i) +
+      '&literal=' + encodeURIComponent(params.literal) +
+      '&word=' + encodeURIComponent(params.word) +
+      '&rank=' + encodeURIComponent(params.rank) +
       '&files=' + encodeURIComponent(params.files) +
+      '&excludeFiles=' + encodeURIComponent(params.excludeFiles) +
       '&repos=' + params.repos;
     history.pushState({path:path}, '', path);
   },
@@ -833,7 +1030,11 @@ var App = createReactClass({
         <SearchBar ref="searchBar"
             q={this.state.q}
             i={this.state.i}
+            literal={this.state.literal}
+            word={this.state.word}
+            rank={this.state.rank}
             files={this.state.files}
+            excludeFiles={this.state.excludeFiles}
             repos={this.state.repos}
             onSearchRequested={this.onSearchRequested} />
         <ResultView ref="resultView" q={this.state.q} />